@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/ntp"
+)
+
+// symmetricKey ключ симметричной аутентификации NTP (RFC 5905)
+type symmetricKey struct {
+	id      uint16
+	algo    ntp.AuthType
+	key     string
+	decoded []byte
+}
+
+// readKeysFile читает файл ключей в формате ntpd: "<id> <MD5|SHA1> <ключ>" в каждой строке.
+// Ключ длиннее 20 символов считается шестнадцатеричным, короче - ASCII строкой
+func readKeysFile(filename string) ([]symmetricKey, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseKeys(file)
+}
+
+// parseKeys разбирает содержимое файла ключей
+func parseKeys(r io.Reader) ([]symmetricKey, error) {
+	var keys []symmetricKey
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		// Пропускаем комментарии и пустые строки
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("keys line %d: expected \"<id> <type> <key>\"", lineNum)
+		}
+
+		id, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("keys line %d: invalid key id %q", lineNum, fields[0])
+		}
+		var algo ntp.AuthType
+		switch strings.ToUpper(fields[1]) {
+		case "MD5", "M":
+			algo = ntp.AuthMD5
+		case "SHA1", "SHA-1":
+			algo = ntp.AuthSHA1
+		default:
+			return nil, fmt.Errorf("keys line %d: unsupported key type %q", lineNum, fields[1])
+		}
+		decoded, err := decodeKey(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("keys line %d: %v", lineNum, err)
+		}
+
+		keys = append(keys, symmetricKey{id: uint16(id), algo: algo, key: fields[2], decoded: decoded})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// decodeKey декодирует ключ по тем же правилам, что и beevik/ntp
+func decodeKey(key string) ([]byte, error) {
+	var decoded []byte
+	if len(key) > 20 {
+		var err error
+		if decoded, err = hex.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid hex key: %v", err)
+		}
+	} else {
+		decoded = []byte(key)
+	}
+	if len(decoded) < 4 {
+		return nil, fmt.Errorf("key is too short")
+	}
+	if len(decoded) > 32 {
+		decoded = decoded[:32]
+	}
+	return decoded, nil
+}
+
+// selectKey выбирает ключ по идентификатору, 0 - первый ключ из файла
+func selectKey(keys []symmetricKey, id uint16) (symmetricKey, error) {
+	for _, key := range keys {
+		if id == 0 || key.id == id {
+			return key, nil
+		}
+	}
+	if id == 0 {
+		return symmetricKey{}, fmt.Errorf("keys file is empty")
+	}
+	return symmetricKey{}, fmt.Errorf("key %d not found", id)
+}
+
+// authOptions переводит ключ в настройки запроса beevik/ntp
+func (k symmetricKey) authOptions() ntp.AuthOptions {
+	return ntp.AuthOptions{Type: k.algo, Key: k.key, KeyID: k.id}
+}
+
+// digestSize возвращает размер дайджеста алгоритма ключа
+func (k symmetricKey) digestSize() int {
+	if k.algo == ntp.AuthSHA1 {
+		return sha1.Size
+	}
+	return md5.Size
+}
+
+// digest вычисляет дайджест H(ключ || пакет), как того требует RFC 5905
+func (k symmetricKey) digest(payload []byte) []byte {
+	data := append(append([]byte(nil), k.decoded...), payload...)
+	if k.algo == ntp.AuthSHA1 {
+		sum := sha1.Sum(data)
+		return sum[:]
+	}
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+// splitMAC отделяет MAC от пакета, если длина хвоста после заголовка совпадает
+// с MAC одного из поддерживаемых алгоритмов
+func splitMAC(packet []byte) (payload []byte, keyID uint16, mac []byte, ok bool) {
+	tail := len(packet) - packetSize
+	if tail != 4+md5.Size && tail != 4+sha1.Size {
+		return packet, 0, nil, false
+	}
+	id := binary.BigEndian.Uint32(packet[packetSize:])
+	if id == 0 || id > 0xffff {
+		return packet, 0, nil, false
+	}
+	return packet[:packetSize], uint16(id), packet[packetSize+4:], true
+}
+
+// verifyMAC проверяет дайджест запроса и возвращает использованный ключ
+func verifyMAC(keys []symmetricKey, payload []byte, keyID uint16, mac []byte) (symmetricKey, bool) {
+	for _, key := range keys {
+		if key.id != keyID || key.digestSize() != len(mac) {
+			continue
+		}
+		if subtle.ConstantTimeCompare(key.digest(payload), mac) == 1 {
+			return key, true
+		}
+	}
+	return symmetricKey{}, false
+}
+
+// appendMAC дописывает к пакету идентификатор ключа и дайджест
+func appendMAC(packet []byte, key symmetricKey) []byte {
+	digest := key.digest(packet)
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], uint32(key.id))
+	packet = append(packet, id[:]...)
+	return append(packet, digest...)
+}
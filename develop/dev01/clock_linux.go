@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// systemAdjuster корректирует системные часы Linux. Требует CAP_SYS_TIME
+type systemAdjuster struct{}
+
+// slew запускает однократную подстройку хода часов, как adjtime(3):
+// ядро компенсирует смещение со скоростью до 500ppm
+func (systemAdjuster) slew(offset time.Duration) error {
+	timex := unix.Timex{Modes: unix.ADJ_OFFSET_SINGLESHOT}
+	setLong(&timex.Offset, offset.Microseconds())
+	_, err := unix.Adjtimex(&timex)
+	return err
+}
+
+// setLong записывает значение в поле типа long, которое на 32-битных
+// архитектурах представлено int32, а на 64-битных - int64
+func setLong[T int32 | int64](field *T, value int64) {
+	*field = T(value)
+}
+
+// step переводит часы на offset относительно текущего времени
+func (systemAdjuster) step(offset time.Duration) error {
+	tv := unix.NsecToTimeval(time.Now().Add(offset).UnixNano())
+	return unix.Settimeofday(&tv)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// errClockUnsupported возвращается на системах, где коррекция часов не реализована
+var errClockUnsupported = errors.New("clock adjustment is only supported on Linux")
+
+// systemAdjuster заглушка для систем, отличных от Linux
+type systemAdjuster struct{}
+
+func (systemAdjuster) slew(offset time.Duration) error {
+	return errClockUnsupported
+}
+
+func (systemAdjuster) step(offset time.Duration) error {
+	return errClockUnsupported
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// diagnostics подробный отчет об ответе одного NTP сервера
+type diagnostics struct {
+	Server           string `json:"server"`
+	Time             string `json:"time,omitempty"`
+	ClockOffsetNs    int64  `json:"clock_offset_ns"`
+	RTTNs            int64  `json:"rtt_ns"`
+	Stratum          uint8  `json:"stratum"`
+	ReferenceID      string `json:"reference_id,omitempty"`
+	ReferenceTime    string `json:"reference_time,omitempty"`
+	RootDelayNs      int64  `json:"root_delay_ns"`
+	RootDispersionNs int64  `json:"root_dispersion_ns"`
+	RootDistanceNs   int64  `json:"root_distance_ns"`
+	PrecisionNs      int64  `json:"precision_ns"`
+	PollNs           int64  `json:"poll_ns"`
+	Leap             string `json:"leap"`
+	KissOfDeath      bool   `json:"kiss_of_death"`
+	KissCode         string `json:"kiss_code,omitempty"`
+	Valid            bool   `json:"valid"`
+	ValidationError  string `json:"validation_error,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+// leapString возвращает читаемое описание индикатора високосной секунды
+func leapString(leap ntp.LeapIndicator) string {
+	switch leap {
+	case ntp.LeapNoWarning:
+		return "none"
+	case ntp.LeapAddSecond:
+		return "add second"
+	case ntp.LeapDelSecond:
+		return "delete second"
+	case ntp.LeapNotInSync:
+		return "not in sync"
+	}
+	return fmt.Sprintf("unknown (%d)", leap)
+}
+
+// newDiagnostics переводит ответ сервера (или ошибку запроса) в отчет
+func newDiagnostics(server string, response *ntp.Response, err error) diagnostics {
+	diag := diagnostics{Server: server}
+	if err != nil {
+		diag.Error = err.Error()
+		return diag
+	}
+
+	diag.Time = response.Time.UTC().Format(time.RFC3339Nano)
+	diag.ClockOffsetNs = int64(response.ClockOffset)
+	diag.RTTNs = int64(response.RTT)
+	diag.Stratum = response.Stratum
+	diag.ReferenceID = response.ReferenceString()
+	diag.ReferenceTime = response.ReferenceTime.UTC().Format(time.RFC3339Nano)
+	diag.RootDelayNs = int64(response.RootDelay)
+	diag.RootDispersionNs = int64(response.RootDispersion)
+	diag.RootDistanceNs = int64(response.RootDistance)
+	diag.PrecisionNs = int64(response.Precision)
+	diag.PollNs = int64(response.Poll)
+	diag.Leap = leapString(response.Leap)
+	// Для kiss-of-death ответа ReferenceID содержит код причины
+	if response.IsKissOfDeath() {
+		diag.KissOfDeath = true
+		diag.KissCode = response.KissCode
+		diag.ReferenceID = ""
+	}
+	if err := response.Validate(); err != nil {
+		diag.ValidationError = err.Error()
+	} else {
+		diag.Valid = true
+	}
+
+	return diag
+}
+
+// diagnoseServers опрашивает серверы конкурентно и собирает отчеты в исходном порядке
+func diagnoseServers(servers []string, query queryFunc) []diagnostics {
+	reports := make([]diagnostics, len(servers))
+
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			response, err := query(server)
+			reports[i] = newDiagnostics(server, response, err)
+		}(i, server)
+	}
+	wg.Wait()
+
+	return reports
+}
+
+// writeDiagnostics выводит отчеты в человекочитаемом виде или в JSON
+func writeDiagnostics(w io.Writer, reports []diagnostics, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+
+	for i, d := range reports {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if err := writeDiagnosticsText(w, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiagnosticsText выводит один отчет в виде "ключ: значение"
+func writeDiagnosticsText(w io.Writer, d diagnostics) error {
+	if d.Error != "" {
+		_, err := fmt.Fprintf(w, "Server: %s\nError: %s\n", d.Server, d.Error)
+		return err
+	}
+
+	reference := d.ReferenceID
+	if d.KissOfDeath {
+		reference = "kiss of death: " + d.KissCode
+	}
+	status := "valid"
+	if !d.Valid {
+		status = "invalid: " + d.ValidationError
+	}
+
+	_, err := fmt.Fprintf(w,
+		"Server: %s\n"+
+			"Time: %s\n"+
+			"Clock Offset: %v\n"+
+			"Round-Trip Delay: %v\n"+
+			"Stratum: %d\n"+
+			"Reference ID: %s\n"+
+			"Reference Time: %s\n"+
+			"Root Delay: %v\n"+
+			"Root Dispersion: %v\n"+
+			"Root Distance: %v\n"+
+			"Precision: %v\n"+
+			"Poll Interval: %v\n"+
+			"Leap Indicator: %s\n"+
+			"Status: %s\n",
+		d.Server, d.Time,
+		time.Duration(d.ClockOffsetNs), time.Duration(d.RTTNs),
+		d.Stratum, reference, d.ReferenceTime,
+		time.Duration(d.RootDelayNs), time.Duration(d.RootDispersionNs), time.Duration(d.RootDistanceNs),
+		time.Duration(d.PrecisionNs), time.Duration(d.PollNs),
+		d.Leap, status)
+	return err
+}
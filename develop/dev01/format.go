@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeFormat способ вывода момента времени: готовый формат, Go layout или strftime шаблон,
+// применяемый в заданном часовом поясе
+type timeFormat struct {
+	preset   string
+	layout   string
+	strftime string
+	location *time.Location
+}
+
+// Готовые форматы флага -format. Для unix-форматов layout не нужен
+var timePresets = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"unix":        "",
+	"unixms":      "",
+	"unixns":      "",
+}
+
+// parseTimeFormat разбирает флаги -format и -tz. Формат - имя из timePresets,
+// strftime шаблон (если содержит %) или Go layout с элементами эталонного времени
+func parseTimeFormat(spec, tz string) (timeFormat, error) {
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return timeFormat{}, fmt.Errorf("invalid time zone %q: %v", tz, err)
+	}
+
+	f := timeFormat{location: location}
+	if layout, ok := timePresets[strings.ToLower(spec)]; ok {
+		f.preset = strings.ToLower(spec)
+		f.layout = layout
+		return f, nil
+	}
+	if strings.Contains(spec, "%") {
+		if err := validateStrftime(spec); err != nil {
+			return timeFormat{}, err
+		}
+		f.strftime = spec
+		return f, nil
+	}
+	if spec == "" {
+		return timeFormat{}, fmt.Errorf("empty time format")
+	}
+	// Иначе опечатка в имени готового формата выводилась бы как есть
+	if !hasLayoutToken(spec) {
+		return timeFormat{}, fmt.Errorf("unknown time format %q: not a preset, strftime pattern or Go layout", spec)
+	}
+	f.layout = spec
+	return f, nil
+}
+
+// layoutTokens элементы эталонного времени Go layout. Однозначные элементы 1-5 не учитываются:
+// они встречаются в обычном тексте, например в опечатке rfc339
+var layoutTokens = []string{
+	"2006", "06", "Jan", "01", "Mon", "02", "_2", "002", "15", "03", "04", "05",
+	"PM", "pm", "MST", "Z07", "-07", ".000", ".999", ",000", ",999",
+}
+
+// hasLayoutToken проверяет, содержит ли spec хотя бы один элемент эталонного времени Go
+func hasLayoutToken(spec string) bool {
+	for _, token := range layoutTokens {
+		if strings.Contains(spec, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// format форматирует момент времени
+func (f timeFormat) format(t time.Time) string {
+	switch f.preset {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unixms":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+	case "unixns":
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+
+	t = t.In(f.location)
+	if f.strftime != "" {
+		return strftime(f.strftime, t)
+	}
+	return t.Format(f.layout)
+}
+
+// Директивы, которые поддерживает strftime
+const strftimeDirectives = "aAbBcdDeFHIjklmMnpsSTyYzZ%"
+
+// validateStrftime проверяет, что шаблон содержит только поддерживаемые директивы
+func validateStrftime(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(pattern) {
+			return fmt.Errorf("strftime pattern %q ends with %%", pattern)
+		}
+		if !strings.ContainsRune(strftimeDirectives, rune(pattern[i])) {
+			return fmt.Errorf("unsupported strftime directive %%%c", pattern[i])
+		}
+	}
+	return nil
+}
+
+// strftime форматирует время по шаблону в стиле C strftime(3)
+func strftime(pattern string, t time.Time) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			b.WriteString(t.Format("Mon Jan _2 15:04:05 2006"))
+		case 'd':
+			b.WriteString(t.Format("02"))
+		case 'D':
+			b.WriteString(t.Format("01/02/06"))
+		case 'e':
+			b.WriteString(t.Format("_2"))
+		case 'F':
+			b.WriteString(t.Format("2006-01-02"))
+		case 'H':
+			b.WriteString(t.Format("15"))
+		case 'I':
+			b.WriteString(t.Format("03"))
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(&b, "%2d", t.Hour())
+		case 'l':
+			fmt.Fprintf(&b, "%2s", t.Format("3"))
+		case 'm':
+			b.WriteString(t.Format("01"))
+		case 'M':
+			b.WriteString(t.Format("04"))
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'S':
+			b.WriteString(t.Format("05"))
+		case 'T':
+			b.WriteString(t.Format("15:04:05"))
+		case 'y':
+			b.WriteString(t.Format("06"))
+		case 'Y':
+			b.WriteString(t.Format("2006"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// timeReport машиночитаемое представление результата
+type timeReport struct {
+	LocalTime    string   `json:"local_time"`
+	Source       string   `json:"source"`
+	SourceTime   string   `json:"source_time"`
+	OffsetNs     int64    `json:"offset_ns"`
+	Agreed       []string `json:"agreed_servers,omitempty"`
+	Rejected     []string `json:"rejected_servers,omitempty"`
+	Unreachable  []string `json:"unreachable_servers,omitempty"`
+	LocalUnixNs  int64    `json:"local_unix_ns"`
+	SourceUnixNs int64    `json:"source_unix_ns"`
+}
+
+// newTimeReport собирает отчет, форматируя моменты времени заданным форматом
+func newTimeReport(nowInfo currentTime, f timeFormat) timeReport {
+	return timeReport{
+		LocalTime:    f.format(nowInfo.local),
+		Source:       nowInfo.source,
+		SourceTime:   f.format(nowInfo.exact),
+		OffsetNs:     int64(nowInfo.offset),
+		Agreed:       nowInfo.truechimers,
+		Rejected:     nowInfo.falsechimers,
+		Unreachable:  nowInfo.failed,
+		LocalUnixNs:  nowInfo.local.UnixNano(),
+		SourceUnixNs: nowInfo.exact.UnixNano(),
+	}
+}
+
+// writeTimeReport выводит результат в формате output: text, json или line
+// (протокол InfluxDB line protocol)
+func writeTimeReport(w io.Writer, nowInfo currentTime, f timeFormat, output string) error {
+	switch output {
+	case "text":
+		nowInfo.timePackage = f.format(nowInfo.local)
+		nowInfo.ntpPackage = f.format(nowInfo.exact)
+		return writeCurrentTime(w, nowInfo)
+	case "json":
+		return json.NewEncoder(w).Encode(newTimeReport(nowInfo, f))
+	case "line":
+		report := newTimeReport(nowInfo, f)
+		_, err := fmt.Fprintf(w, "clock,source=%s offset_ns=%di,agreed=%di,rejected=%di,unreachable=%di,source_time=%s %d\n",
+			escapeLineTag(report.Source), report.OffsetNs,
+			len(report.Agreed), len(report.Rejected), len(report.Unreachable),
+			quoteLineField(report.SourceTime), report.LocalUnixNs)
+		return err
+	}
+	return fmt.Errorf("unknown output format %q", output)
+}
+
+// escapeLineTag экранирует значение тега line protocol
+func escapeLineTag(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// quoteLineField оформляет строковое поле line protocol
+func quoteLineField(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// Константы Network Time Security (RFC 8915)
+const (
+	ntsKEPort        = 4460
+	defaultNTPPort   = 123
+	ntsALPN          = "ntske/1"
+	ntsExporterLabel = "EXPORTER-network-time-security"
+	ntsCookieCount   = 8
+
+	// Идентификаторы протокола NTPv4 и алгоритма AEAD_AES_SIV_CMAC_256
+	ntsProtocolNTPv4 = 0
+	ntsAEADSIV256    = 15
+)
+
+// Типы записей NTS-KE
+const (
+	recordEndOfMessage = 0
+	recordNextProtocol = 1
+	recordError        = 2
+	recordWarning      = 3
+	recordAEAD         = 4
+	recordNewCookie    = 5
+	recordServer       = 6
+	recordPort         = 7
+
+	recordCritical = 0x8000
+)
+
+// Типы полей расширения NTP, используемых NTS
+const (
+	extUniqueID          = 0x0104
+	extCookie            = 0x0204
+	extCookiePlaceholder = 0x0304
+	extAuthenticator     = 0x0404
+
+	// Минимальная длина поля расширения NTPv4 (RFC 7822)
+	extMinLength = 16
+	uniqueIDSize = 32
+	ntsNonceSize = 16
+)
+
+// errNTSNak возвращается, когда сервер не смог проверить запрос (kiss code NTSN)
+var errNTSNak = errors.New("NTS negative acknowledgment received")
+
+// ntsRecord запись протокола NTS-KE
+type ntsRecord struct {
+	critical bool
+	kind     uint16
+	body     []byte
+}
+
+// writeNTSRecords сериализует записи NTS-KE
+func writeNTSRecords(w io.Writer, records []ntsRecord) error {
+	var buf bytes.Buffer
+	for _, r := range records {
+		kind := r.kind
+		if r.critical {
+			kind |= recordCritical
+		}
+		var head [4]byte
+		binary.BigEndian.PutUint16(head[0:], kind)
+		binary.BigEndian.PutUint16(head[2:], uint16(len(r.body)))
+		buf.Write(head[:])
+		buf.Write(r.body)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readNTSRecords читает записи NTS-KE до записи End of Message включительно
+func readNTSRecords(r io.Reader) ([]ntsRecord, error) {
+	var records []ntsRecord
+	for {
+		var head [4]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return nil, fmt.Errorf("reading NTS-KE record: %v", err)
+		}
+		kind := binary.BigEndian.Uint16(head[0:])
+		body := make([]byte, binary.BigEndian.Uint16(head[2:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("reading NTS-KE record: %v", err)
+		}
+
+		record := ntsRecord{critical: kind&recordCritical != 0, kind: kind &^ recordCritical, body: body}
+		records = append(records, record)
+		if record.kind == recordEndOfMessage {
+			return records, nil
+		}
+	}
+}
+
+// uint16Body кодирует число в тело записи
+func uint16Body(v uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return b[:]
+}
+
+// containsUint16 проверяет, перечислено ли значение в теле записи
+func containsUint16(body []byte, v uint16) bool {
+	for i := 0; i+1 < len(body); i += 2 {
+		if binary.BigEndian.Uint16(body[i:]) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// exportNTSKeys получает ключи C2S и S2C из TLS сессии
+func exportNTSKeys(state tls.ConnectionState) (c2s, s2c []byte, err error) {
+	context := []byte{0, ntsProtocolNTPv4, 0, ntsAEADSIV256, 0}
+	if c2s, err = state.ExportKeyingMaterial(ntsExporterLabel, context, sivKeySize); err != nil {
+		return nil, nil, err
+	}
+	context[4] = 1
+	if s2c, err = state.ExportKeyingMaterial(ntsExporterLabel, context, sivKeySize); err != nil {
+		return nil, nil, err
+	}
+	return c2s, s2c, nil
+}
+
+// ntsSession результат NTS-KE: адрес NTP сервера, ключи и запас cookie.
+// Реализует ntp.Extension, чтобы добавлять поля NTS в запросы beevik/ntp
+type ntsSession struct {
+	server  string
+	c2s     []byte
+	s2c     []byte
+	cookies [][]byte
+
+	mu       sync.Mutex
+	uniqueID []byte
+}
+
+// ntsKeyExchange выполняет NTS-KE с сервером address (порт по умолчанию 4460)
+func ntsKeyExchange(address string, config *tls.Config, timeout time.Duration) (*ntsSession, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, strconv.Itoa(ntsKEPort)
+	}
+
+	cfg := &tls.Config{}
+	if config != nil {
+		cfg = config.Clone()
+	}
+	cfg.NextProtos = []string{ntsALPN}
+	cfg.MinVersion = tls.VersionTLS13
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), cfg)
+	if err != nil {
+		return nil, fmt.Errorf("NTS-KE: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if conn.ConnectionState().NegotiatedProtocol != ntsALPN {
+		return nil, errors.New("NTS-KE: server did not negotiate " + ntsALPN)
+	}
+
+	err = writeNTSRecords(conn, []ntsRecord{
+		{critical: true, kind: recordNextProtocol, body: uint16Body(ntsProtocolNTPv4)},
+		{kind: recordAEAD, body: uint16Body(ntsAEADSIV256)},
+		{critical: true, kind: recordEndOfMessage},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("NTS-KE: %v", err)
+	}
+	records, err := readNTSRecords(conn)
+	if err != nil {
+		return nil, fmt.Errorf("NTS-KE: %v", err)
+	}
+
+	session := &ntsSession{server: host}
+	ntpPort := strconv.Itoa(defaultNTPPort)
+	protocolOK, aeadOK := false, false
+	for _, r := range records {
+		switch r.kind {
+		case recordEndOfMessage, recordWarning:
+		case recordNextProtocol:
+			protocolOK = containsUint16(r.body, ntsProtocolNTPv4)
+		case recordAEAD:
+			aeadOK = containsUint16(r.body, ntsAEADSIV256)
+		case recordError:
+			code := -1
+			if len(r.body) >= 2 {
+				code = int(binary.BigEndian.Uint16(r.body))
+			}
+			return nil, fmt.Errorf("NTS-KE: server returned error %d", code)
+		case recordNewCookie:
+			session.cookies = append(session.cookies, r.body)
+		case recordServer:
+			session.server = string(r.body)
+		case recordPort:
+			if len(r.body) == 2 {
+				ntpPort = strconv.Itoa(int(binary.BigEndian.Uint16(r.body)))
+			}
+		default:
+			if r.critical {
+				return nil, fmt.Errorf("NTS-KE: unsupported critical record %d", r.kind)
+			}
+		}
+	}
+	if !protocolOK || !aeadOK {
+		return nil, errors.New("NTS-KE: server did not accept NTPv4 with AEAD_AES_SIV_CMAC_256")
+	}
+	if len(session.cookies) == 0 {
+		return nil, errors.New("NTS-KE: server sent no cookies")
+	}
+	session.server = net.JoinHostPort(session.server, ntpPort)
+
+	if session.c2s, session.s2c, err = exportNTSKeys(conn.ConnectionState()); err != nil {
+		return nil, fmt.Errorf("NTS-KE: %v", err)
+	}
+	return session, nil
+}
+
+// ProcessQuery добавляет в запрос уникальный идентификатор, cookie и аутентификатор
+func (s *ntsSession) ProcessQuery(buf *bytes.Buffer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cookies) == 0 {
+		return errors.New("NTS: no cookies left, repeat key exchange")
+	}
+	cookie := s.cookies[0]
+	s.cookies = s.cookies[1:]
+
+	s.uniqueID = make([]byte, uniqueIDSize)
+	if _, err := rand.Read(s.uniqueID); err != nil {
+		return err
+	}
+	appendExtension(buf, extUniqueID, s.uniqueID)
+	appendExtension(buf, extCookie, cookie)
+	// Просим сервер восполнить запас cookie: он вернет по одной на каждый заполнитель и еще одну
+	for i := len(s.cookies) + 1; i < ntsCookieCount; i++ {
+		appendExtension(buf, extCookiePlaceholder, make([]byte, len(cookie)))
+	}
+
+	return appendAuthenticator(buf, s.c2s, nil)
+}
+
+// ProcessResponse проверяет ответ сервера и забирает новые cookie
+func (s *ntsSession) ProcessResponse(buf []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(buf) < packetSize {
+		return errors.New("NTS: short response")
+	}
+	// Отказ NTSN не аутентифицирован, поэтому ему можно верить, только если он содержит
+	// уникальный идентификатор нашего запроса (RFC 8915, раздел 5.7). Иначе любой
+	// поддельный пакет заставлял бы повторять обмен ключами
+	nak := buf[1] == 0 && string(buf[12:16]) == "NTSN"
+
+	fields, err := parseExtensions(buf)
+	if err != nil {
+		return err
+	}
+	uidOK := false
+	for _, f := range fields {
+		switch f.kind {
+		case extUniqueID:
+			uidOK = bytes.Equal(f.body, s.uniqueID)
+			if nak && uidOK {
+				return errNTSNak
+			}
+		case extAuthenticator:
+			if nak {
+				continue
+			}
+			if !uidOK {
+				return errors.New("NTS: response unique identifier mismatch")
+			}
+			plaintext, err := openAuthenticator(f.body, s.s2c, buf[:f.offset])
+			if err != nil {
+				return fmt.Errorf("NTS: %v", err)
+			}
+			encrypted, err := parseExtensionFields(plaintext, 0)
+			if err != nil {
+				return err
+			}
+			for _, e := range encrypted {
+				if e.kind == extCookie {
+					s.cookies = append(s.cookies, e.body)
+				}
+			}
+			return nil
+		}
+	}
+
+	if nak {
+		return errors.New("NTS: NTSN response unique identifier mismatch")
+	}
+	return errors.New("NTS: response is not authenticated")
+}
+
+// hasCookies проверяет, остались ли cookie для запросов
+func (s *ntsSession) hasCookies() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.cookies) > 0
+}
+
+// ntsClient результат NTS-KE с одним сервером, общий для его опросов
+type ntsClient struct {
+	mu      sync.Mutex
+	session *ntsSession
+}
+
+// ntsSessions хранит результаты NTS-KE с серверами между опросами, чтобы запросы
+// использовали полученные cookie, а не повторяли обмен ключами каждый раз
+type ntsSessions struct {
+	config  *tls.Config
+	timeout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*ntsClient
+}
+
+// client возвращает состояние опроса сервера из списка
+func (n *ntsSessions) client(server string) *ntsClient {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	client := n.clients[server]
+	if client == nil {
+		client = &ntsClient{}
+		n.clients[server] = client
+	}
+	return client
+}
+
+// query опрашивает NTP сервер, выданный NTS-KE с server. Обмен ключами выполняется,
+// только когда cookie закончились или сервер ответил отказом NTSN. Если отказ пришел
+// на запрос с сохраненными ключами, запрос повторяется один раз с новыми ключами
+func (n *ntsSessions) query(server string) (*ntp.Response, error) {
+	client := n.client(server)
+	// Запросы с одной сессией не должны перекрываться: ответ проверяется
+	// по уникальному идентификатору последнего запроса
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	for {
+		fresh := false
+		if client.session == nil || !client.session.hasCookies() {
+			session, err := ntsKeyExchange(server, n.config, n.timeout)
+			if err != nil {
+				return nil, err
+			}
+			client.session, fresh = session, true
+		}
+
+		response, err := ntp.QueryWithOptions(client.session.server, ntp.QueryOptions{
+			Timeout:    n.timeout,
+			Extensions: []ntp.Extension{client.session},
+		})
+		if errors.Is(err, errNTSNak) {
+			client.session = nil
+			if !fresh {
+				continue
+			}
+		}
+		return response, err
+	}
+}
+
+// ntsQuery возвращает функцию опроса по NTS: перед первым запросом к серверу из списка
+// выполняется NTS-KE, затем опрашивается выданный им NTP сервер
+func ntsQuery(config *tls.Config, timeout time.Duration) queryFunc {
+	sessions := &ntsSessions{config: config, timeout: timeout, clients: make(map[string]*ntsClient)}
+	return sessions.query
+}
+
+// extension поле расширения NTP и его смещение от начала пакета
+type extension struct {
+	kind   uint16
+	body   []byte
+	offset int
+}
+
+// appendExtension дописывает поле расширения, выравнивая его по 4 байтам
+func appendExtension(buf *bytes.Buffer, kind uint16, body []byte) {
+	length := (4 + len(body) + 3) &^ 3
+	if length < extMinLength {
+		length = extMinLength
+	}
+	var head [4]byte
+	binary.BigEndian.PutUint16(head[0:], kind)
+	binary.BigEndian.PutUint16(head[2:], uint16(length))
+	buf.Write(head[:])
+	buf.Write(body)
+	buf.Write(make([]byte, length-4-len(body)))
+}
+
+// parseExtensions разбирает поля расширения, следующие за заголовком пакета
+func parseExtensions(packet []byte) ([]extension, error) {
+	return parseExtensionFields(packet[packetSize:], packetSize)
+}
+
+// parseExtensionFields разбирает последовательность полей расширения. base - смещение data в пакете
+func parseExtensionFields(data []byte, base int) ([]extension, error) {
+	var fields []extension
+	for off := 0; off < len(data); {
+		if len(data)-off < 4 {
+			return nil, errors.New("NTS: truncated extension field")
+		}
+		kind := binary.BigEndian.Uint16(data[off:])
+		length := int(binary.BigEndian.Uint16(data[off+2:]))
+		if length < 4 || length%4 != 0 || off+length > len(data) {
+			return nil, errors.New("NTS: malformed extension field")
+		}
+		fields = append(fields, extension{kind: kind, body: data[off+4 : off+length], offset: base + off})
+		off += length
+	}
+	return fields, nil
+}
+
+// appendAuthenticator шифрует plaintext ключом key и дописывает поле аутентификатора.
+// Связанными данными служит весь пакет до этого поля
+func appendAuthenticator(buf *bytes.Buffer, key, plaintext []byte) error {
+	nonce := make([]byte, ntsNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	ciphertext, err := sivSeal(key, plaintext, buf.Bytes(), nonce)
+	if err != nil {
+		return err
+	}
+
+	body := make([]byte, 4, 4+len(nonce)+len(ciphertext)+3)
+	binary.BigEndian.PutUint16(body[0:], uint16(len(nonce)))
+	binary.BigEndian.PutUint16(body[2:], uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, ciphertext...)
+	appendExtension(buf, extAuthenticator, body)
+	return nil
+}
+
+// openAuthenticator проверяет поле аутентификатора и возвращает расшифрованные данные
+func openAuthenticator(body, key, ad []byte) ([]byte, error) {
+	if len(body) < 4 {
+		return nil, errors.New("malformed authenticator")
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body[0:]))
+	ciphertextLen := int(binary.BigEndian.Uint16(body[2:]))
+	nonceEnd := 4 + (nonceLen+3)&^3
+	if nonceEnd+ciphertextLen > len(body) {
+		return nil, errors.New("malformed authenticator")
+	}
+	nonce := body[4 : 4+nonceLen]
+	ciphertext := body[nonceEnd : nonceEnd+ciphertextLen]
+
+	return sivOpen(key, ciphertext, ad, nonce)
+}
+
+// cookieJar выпускает и открывает cookie NTS. Cookie - это ключи C2S и S2C,
+// зашифрованные главным ключом сервера, поэтому серверу не нужно хранить состояние
+type cookieJar struct {
+	master []byte
+}
+
+// newCookieJar создает хранилище со случайным главным ключом
+func newCookieJar() (*cookieJar, error) {
+	master := make([]byte, sivKeySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, err
+	}
+	return &cookieJar{master: master}, nil
+}
+
+// seal упаковывает ключи в cookie
+func (j *cookieJar) seal(c2s, s2c []byte) ([]byte, error) {
+	nonce := make([]byte, ntsNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ciphertext, err := sivSeal(j.master, append(append([]byte(nil), c2s...), s2c...), nonce)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// open извлекает ключи из cookie
+func (j *cookieJar) open(cookie []byte) (c2s, s2c []byte, err error) {
+	if len(cookie) < ntsNonceSize {
+		return nil, nil, errors.New("NTS: invalid cookie")
+	}
+	keys, err := sivOpen(j.master, cookie[ntsNonceSize:], cookie[:ntsNonceSize])
+	if err != nil || len(keys) != 2*sivKeySize {
+		return nil, nil, errors.New("NTS: invalid cookie")
+	}
+	return keys[:sivKeySize], keys[sivKeySize:], nil
+}
+
+// ntsKEServer сервер NTS-KE, выдающий ключи и cookie для NTS запросов к sntpServer
+type ntsKEServer struct {
+	listener net.Listener
+	jar      *cookieJar
+	// ntpServer и ntpPort сообщаются клиенту, если заданы
+	ntpServer string
+	ntpPort   uint16
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// listenNTSKE открывает TLS сокет NTS-KE. config должен содержать сертификат сервера
+func listenNTSKE(addr string, config *tls.Config, jar *cookieJar) (*ntsKEServer, error) {
+	cfg := config.Clone()
+	cfg.NextProtos = []string{ntsALPN}
+	cfg.MinVersion = tls.VersionTLS13
+
+	listener, err := tls.Listen("tcp", addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ntsKEServer{listener: listener, jar: jar, closed: make(chan struct{})}, nil
+}
+
+// addr возвращает адрес, на котором слушает сервер
+func (s *ntsKEServer) addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// serve принимает соединения до закрытия сервера
+func (s *ntsKEServer) serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.handle(conn.(*tls.Conn))
+	}
+}
+
+// close останавливает сервер, повторные вызовы ничего не делают
+func (s *ntsKEServer) close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.listener.Close()
+	})
+	return err
+}
+
+// handle обслуживает одно соединение NTS-KE
+func (s *ntsKEServer) handle(conn *tls.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		return
+	}
+
+	records, err := readNTSRecords(conn)
+	if err != nil {
+		return
+	}
+	protocolOK, aeadOK := false, false
+	for _, r := range records {
+		switch r.kind {
+		case recordNextProtocol:
+			protocolOK = containsUint16(r.body, ntsProtocolNTPv4)
+		case recordAEAD:
+			aeadOK = containsUint16(r.body, ntsAEADSIV256)
+		}
+	}
+	// Код ошибки 1 - некорректный запрос
+	if !protocolOK || !aeadOK {
+		writeNTSRecords(conn, []ntsRecord{
+			{critical: true, kind: recordError, body: uint16Body(1)},
+			{critical: true, kind: recordEndOfMessage},
+		})
+		return
+	}
+
+	c2s, s2c, err := exportNTSKeys(conn.ConnectionState())
+	if err != nil {
+		return
+	}
+	response := []ntsRecord{
+		{critical: true, kind: recordNextProtocol, body: uint16Body(ntsProtocolNTPv4)},
+		{kind: recordAEAD, body: uint16Body(ntsAEADSIV256)},
+	}
+	for i := 0; i < ntsCookieCount; i++ {
+		cookie, err := s.jar.seal(c2s, s2c)
+		if err != nil {
+			return
+		}
+		response = append(response, ntsRecord{kind: recordNewCookie, body: cookie})
+	}
+	if s.ntpServer != "" {
+		response = append(response, ntsRecord{kind: recordServer, body: []byte(s.ntpServer)})
+	}
+	if s.ntpPort != 0 {
+		response = append(response, ntsRecord{kind: recordPort, body: uint16Body(s.ntpPort)})
+	}
+	response = append(response, ntsRecord{critical: true, kind: recordEndOfMessage})
+	writeNTSRecords(conn, response)
+}
+
+// ntsRequest разобранный NTS запрос к NTP серверу
+type ntsRequest struct {
+	uniqueID     []byte
+	c2s          []byte
+	s2c          []byte
+	placeholders int
+}
+
+// parseNTSRequest проверяет NTS поля запроса. Второе значение false, если запрос
+// не использует NTS; ошибка означает, что запрос нужно отклонить с NTSN
+func parseNTSRequest(packet []byte, jar *cookieJar) (ntsRequest, bool, error) {
+	fields, err := parseExtensions(packet)
+	if err != nil {
+		return ntsRequest{}, false, nil
+	}
+
+	var request ntsRequest
+	isNTS := false
+	for _, f := range fields {
+		switch f.kind {
+		case extUniqueID:
+			isNTS = true
+			request.uniqueID = f.body
+		case extCookie:
+			isNTS = true
+			if request.c2s, request.s2c, err = jar.open(f.body); err != nil {
+				return request, true, err
+			}
+		case extCookiePlaceholder:
+			request.placeholders++
+		case extAuthenticator:
+			if request.c2s == nil || request.uniqueID == nil {
+				return request, true, errors.New("NTS: missing cookie or unique identifier")
+			}
+			if _, err := openAuthenticator(f.body, request.c2s, packet[:f.offset]); err != nil {
+				return request, true, err
+			}
+			return request, true, nil
+		}
+	}
+	if isNTS {
+		return request, true, errors.New("NTS: request is not authenticated")
+	}
+	return request, false, nil
+}
+
+// ntsResponse дописывает к заголовку ответа идентификатор запроса и
+// аутентификатор с новыми cookie, зашифрованными ключом S2C
+func ntsResponse(header []byte, request ntsRequest, jar *cookieJar) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(header)
+	appendExtension(&buf, extUniqueID, request.uniqueID)
+
+	var cookies bytes.Buffer
+	for i := 0; i <= request.placeholders && i < ntsCookieCount; i++ {
+		cookie, err := jar.seal(request.c2s, request.s2c)
+		if err != nil {
+			return nil, err
+		}
+		appendExtension(&cookies, extCookie, cookie)
+	}
+	if err := appendAuthenticator(&buf, request.s2c, cookies.Bytes()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ntsNak формирует отказ NTS: kiss-of-death с кодом NTSN и идентификатором запроса
+func ntsNak(header []byte, uniqueID []byte) []byte {
+	header[0] = header[0]&0x3f | 3<<6
+	header[1] = 0
+	copy(header[12:16], "NTSN")
+
+	var buf bytes.Buffer
+	buf.Write(header)
+	if uniqueID != nil {
+		appendExtension(&buf, extUniqueID, uniqueID)
+	}
+	return buf.Bytes()
+}
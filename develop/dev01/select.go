@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// errNoMajority возвращается, когда большинство серверов не смогли договориться о времени
+var errNoMajority = errors.New("no majority of servers agree on time")
+
+// sample результат опроса одного NTP сервера
+type sample struct {
+	server string
+	offset time.Duration
+	// distance - погрешность измерения: истинное смещение лежит в [offset-distance, offset+distance]
+	distance time.Duration
+	err      error
+}
+
+// selection результат отбора серверов алгоритмом Марзулло
+type selection struct {
+	offset       time.Duration
+	low          time.Duration
+	high         time.Duration
+	truechimers  []string
+	falsechimers []string
+	failed       []string
+}
+
+// queryFunc функция опроса сервера, подменяется в тестах
+type queryFunc func(server string) (*ntp.Response, error)
+
+// queryServers опрашивает все серверы конкурентно и возвращает результаты в исходном порядке
+func queryServers(servers []string, query queryFunc) []sample {
+	samples := make([]sample, len(servers))
+
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			samples[i] = querySample(server, query)
+		}(i, server)
+	}
+	wg.Wait()
+
+	return samples
+}
+
+// querySample опрашивает один сервер и проверяет ответ на пригодность для синхронизации
+func querySample(server string, query queryFunc) sample {
+	response, err := query(server)
+	if err != nil {
+		return sample{server: server, err: err}
+	}
+	if err := response.Validate(); err != nil {
+		return sample{server: server, err: err}
+	}
+	// Если сервер не сообщил корневую дистанцию - берем половину времени прохождения пакета
+	distance := response.RootDistance
+	if distance <= 0 {
+		distance = response.RTT / 2
+	}
+
+	return sample{server: server, offset: response.ClockOffset, distance: distance}
+}
+
+// marzullo отбирает серверы, интервалы которых пересекаются у большинства,
+// и возвращает смещение как середину наилучшего пересечения
+func marzullo(samples []sample) (selection, error) {
+	// Граница интервала: -1 - начало, +1 - конец
+	type edge struct {
+		value time.Duration
+		kind  int
+	}
+
+	var result selection
+	var valid []sample
+	var lastErr error
+	for _, s := range samples {
+		if s.err != nil {
+			result.failed = append(result.failed, s.server)
+			lastErr = fmt.Errorf("%s: %v", s.server, s.err)
+			continue
+		}
+		valid = append(valid, s)
+	}
+	if len(valid) == 0 {
+		return result, fmt.Errorf("all servers failed, last error: %v", lastErr)
+	}
+
+	edges := make([]edge, 0, len(valid)*2)
+	for _, s := range valid {
+		edges = append(edges, edge{s.offset - s.distance, -1}, edge{s.offset + s.distance, 1})
+	}
+	// Сортируем границы, при равенстве начало идет раньше конца,
+	// чтобы касающиеся интервалы считались пересекающимися
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].value != edges[j].value {
+			return edges[i].value < edges[j].value
+		}
+		return edges[i].kind < edges[j].kind
+	})
+
+	best, count := 0, 0
+	for i, e := range edges {
+		count -= e.kind
+		if count > best {
+			best = count
+			result.low = e.value
+			result.high = edges[i+1].value
+		}
+	}
+	// Требуем, чтобы пересечение поддерживало строгое большинство ответивших серверов
+	if best*2 <= len(valid) {
+		return result, errNoMajority
+	}
+
+	result.offset = result.low + (result.high-result.low)/2
+	for _, s := range valid {
+		if s.offset-s.distance <= result.high && s.offset+s.distance >= result.low {
+			result.truechimers = append(result.truechimers, s.server)
+		} else {
+			result.falsechimers = append(result.falsechimers, s.server)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Константы формата пакета SNTPv4 (RFC 4330)
+const (
+	packetSize    = 48
+	modeClient    = 3
+	modeServer    = 4
+	serverStratum = 1
+)
+
+// Точность часов сервера как степень двойки секунд, 2^-20 - около микросекунды
+var serverPrecision int8 = -20
+
+// Количество секунд между эпохой NTP (1900) и эпохой Unix (1970)
+const ntpEpochOffset = 2208988800
+
+// clock источник текущего времени для сервера
+type clock interface {
+	Now() time.Time
+}
+
+// systemClock отдает время локальных часов
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// offsetClock сдвигает время базовых часов на заданное смещение,
+// позволяя имитировать отстающие или спешащие часы
+type offsetClock struct {
+	base   clock
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time {
+	return c.base.Now().Add(c.offset)
+}
+
+// sntpServer отвечает на SNTP запросы по UDP временем из clock
+type sntpServer struct {
+	conn  net.PacketConn
+	clock clock
+	// refID - идентификатор эталонных часов, по умолчанию "LOCL"
+	refID uint32
+	// keys - ключи симметричной аутентификации, jar - cookie NTS.
+	// Если они не заданы, запросы с MAC или полями NTS обслуживаются без проверки
+	keys []symmetricKey
+	jar  *cookieJar
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// listenSNTP открывает UDP сокет на адресе addr и готовит сервер к работе
+func listenSNTP(addr string, c clock) (*sntpServer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &sntpServer{
+		conn:   conn,
+		clock:  c,
+		refID:  binary.BigEndian.Uint32([]byte("LOCL")),
+		closed: make(chan struct{}),
+	}, nil
+}
+
+// addr возвращает адрес, на котором слушает сервер
+func (s *sntpServer) addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// serve обрабатывает запросы до закрытия сервера
+func (s *sntpServer) serve() error {
+	buf := make([]byte, 1024)
+	for {
+		n, remote, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			// Ошибка после close - штатное завершение
+			select {
+			case <-s.closed:
+				return nil
+			default:
+				return err
+			}
+		}
+		received := s.clock.Now()
+
+		response, ok := s.respond(buf[:n], received)
+		if !ok {
+			continue
+		}
+		if _, err := s.conn.WriteTo(response, remote); err != nil {
+			return err
+		}
+	}
+}
+
+// close останавливает сервер, повторные вызовы ничего не делают
+func (s *sntpServer) close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// respond формирует ответ на запрос. Второе значение false, если запрос нужно проигнорировать
+func (s *sntpServer) respond(request []byte, received time.Time) ([]byte, bool) {
+	if len(request) < packetSize {
+		return nil, false
+	}
+	version := (request[0] >> 3) & 0x07
+	mode := request[0] & 0x07
+	if mode != modeClient || version < 1 || version > 4 {
+		return nil, false
+	}
+
+	header := s.header(request, received)
+	if len(request) == packetSize {
+		return header, true
+	}
+	// Запрос с полями NTS: при ошибке проверки отвечаем отказом NTSN
+	if s.jar != nil {
+		nts, ok, err := parseNTSRequest(request, s.jar)
+		if ok && err != nil {
+			return ntsNak(header, nts.uniqueID), true
+		}
+		if ok {
+			response, err := ntsResponse(header, nts, s.jar)
+			return response, err == nil
+		}
+	}
+	// Запрос с MAC: при неизвестном ключе или неверном дайджесте отвечаем crypto-NAK,
+	// то есть нулевым идентификатором ключа без дайджеста
+	if s.keys != nil {
+		if payload, keyID, mac, ok := splitMAC(request); ok {
+			key, valid := verifyMAC(s.keys, payload, keyID, mac)
+			if !valid {
+				return append(header, 0, 0, 0, 0), true
+			}
+			return appendMAC(header, key), true
+		}
+	}
+
+	return header, true
+}
+
+// header формирует заголовок ответа на запрос
+func (s *sntpServer) header(request []byte, received time.Time) []byte {
+	version := (request[0] >> 3) & 0x07
+	response := make([]byte, packetSize)
+	// LI = 0 (без предупреждений), версия как в запросе, режим сервера
+	response[0] = version<<3 | modeServer
+	response[1] = serverStratum
+	// Интервал опроса копируем из запроса
+	response[2] = request[2]
+	response[3] = byte(serverPrecision)
+	// Корневая задержка и дисперсия нулевые: сервер сам является эталоном
+	binary.BigEndian.PutUint32(response[12:], s.refID)
+	binary.BigEndian.PutUint64(response[16:], toNtpTime(received))
+	// Исходная метка - время отправки из запроса клиента
+	copy(response[24:32], request[40:48])
+	binary.BigEndian.PutUint64(response[32:], toNtpTime(received))
+	binary.BigEndian.PutUint64(response[40:], toNtpTime(s.clock.Now()))
+
+	return response
+}
+
+// toNtpTime переводит время в 64-битную метку NTP: секунды с 1900 года и дробная часть
+func toNtpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// serverConfig параметры режима сервера
+type serverConfig struct {
+	addr   string
+	offset time.Duration
+	// keysFile - файл ключей для проверки запросов с MAC
+	keysFile string
+	// ntsKEAddr - адрес NTS-KE, certFile и keyFile - TLS сертификат для него
+	ntsKEAddr string
+	certFile  string
+	keyFile   string
+}
+
+// runServer запускает SNTP сервер и, если задано, сервер NTS-KE
+func runServer(cfg serverConfig) error {
+	server, err := listenSNTP(cfg.addr, offsetClock{base: systemClock{}, offset: cfg.offset})
+	if err != nil {
+		return err
+	}
+	defer server.close()
+
+	if cfg.keysFile != "" {
+		if server.keys, err = readKeysFile(cfg.keysFile); err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 2)
+	if cfg.ntsKEAddr != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS certificate: %v", err)
+		}
+		if server.jar, err = newCookieJar(); err != nil {
+			return err
+		}
+		ke, err := listenNTSKE(cfg.ntsKEAddr, &tls.Config{Certificates: []tls.Certificate{certificate}}, server.jar)
+		if err != nil {
+			return err
+		}
+		defer ke.close()
+		// Сообщаем клиентам порт NTP, если он отличается от стандартного
+		if port := server.addr().(*net.UDPAddr).Port; port != defaultNTPPort {
+			ke.ntpPort = uint16(port)
+		}
+		log.Printf("Serving NTS-KE on %s\n", ke.addr())
+		go func() { errs <- ke.serve() }()
+	}
+
+	log.Printf("Serving SNTP on %s\n", server.addr())
+	go func() { errs <- server.serve() }()
+	return <-errs
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Политика коррекции часов по умолчанию, как у ntpd: небольшие смещения
+// плавно компенсируются, смещения от 128мс исправляются скачком,
+// а смещения больше 1000с считаются ошибкой источника
+const (
+	defaultStepThreshold = 128 * time.Millisecond
+	defaultMaxOffset     = 1000 * time.Second
+)
+
+// checkClockSource проверяет, что смещению от источника можно доверить коррекцию часов.
+// Только NTP измеряет смещение с точностью лучше секунды: system всегда дает 0,
+// fixed - тестовая заглушка с произвольным временем, а у http точность заголовка Date - секунда
+func checkClockSource(source string) error {
+	if source != "ntp" {
+		return fmt.Errorf("-set requires -source=ntp, the %s source is not accurate enough to correct the clock", source)
+	}
+	return nil
+}
+
+// clockAdjuster выполняет коррекцию системных часов
+type clockAdjuster interface {
+	// slew плавно подводит часы, ускоряя или замедляя их ход
+	slew(offset time.Duration) error
+	// step переводит часы скачком
+	step(offset time.Duration) error
+}
+
+// clockPolicy определяет, как исправлять смещение
+type clockPolicy struct {
+	// stepThreshold - смещение, начиная с которого часы переводятся скачком
+	stepThreshold time.Duration
+	// maxOffset - смещение, больше которого часы не трогаем, 0 - без ограничения
+	maxOffset time.Duration
+}
+
+// correctClock исправляет смещение offset согласно политике
+// и возвращает название выполненного действия
+func correctClock(adjuster clockAdjuster, offset time.Duration, policy clockPolicy) (string, error) {
+	if policy.maxOffset > 0 && abs(offset) > policy.maxOffset {
+		return "", fmt.Errorf("offset %v exceeds maximum %v, refusing to adjust clock", offset, policy.maxOffset)
+	}
+	if offset == 0 {
+		return "none", nil
+	}
+	if abs(offset) >= policy.stepThreshold {
+		return "step", adjuster.step(offset)
+	}
+	return "slew", adjuster.slew(offset)
+}
+
+// dryRunAdjuster печатает системные вызовы вместо того, чтобы их выполнять
+type dryRunAdjuster struct {
+	w     io.Writer
+	clock clock
+}
+
+func (a dryRunAdjuster) slew(offset time.Duration) error {
+	_, err := fmt.Fprintf(a.w, "adjtimex(modes=ADJ_OFFSET_SINGLESHOT, offset=%dus)\n", offset.Microseconds())
+	return err
+}
+
+func (a dryRunAdjuster) step(offset time.Duration) error {
+	target := a.clock.Now().Add(offset)
+	_, err := fmt.Fprintf(a.w, "settimeofday(tv_sec=%d, tv_usec=%d)\n", target.Unix(), target.Nanosecond()/1000)
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// Реализация AEAD_AES_SIV_CMAC_256 (RFC 5297), обязательного алгоритма NTS (RFC 8915).
+// В стандартной библиотеке его нет, поэтому CMAC и S2V реализованы здесь и проверяются
+// тестовыми векторами RFC 5297 (приложение A) и RFC 4493
+
+const (
+	sivKeySize   = 32
+	sivBlockSize = aes.BlockSize
+)
+
+// errSIVOpen возвращается, если шифротекст или связанные данные были изменены
+var errSIVOpen = errors.New("siv: message authentication failed")
+
+// sivSeal шифрует plaintext ключом key (32 байта) и аутентифицирует его вместе с ad.
+// Результат - синтетический вектор инициализации (16 байт) и шифротекст
+func sivSeal(key, plaintext []byte, ad ...[]byte) ([]byte, error) {
+	macBlock, ctrBlock, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+
+	v := s2v(macBlock, plaintext, ad)
+	out := make([]byte, sivBlockSize+len(plaintext))
+	copy(out, v[:])
+	sivCTR(ctrBlock, v, out[sivBlockSize:], plaintext)
+
+	return out, nil
+}
+
+// sivOpen расшифровывает результат sivSeal и проверяет его целостность
+func sivOpen(key, ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < sivBlockSize {
+		return nil, errSIVOpen
+	}
+	macBlock, ctrBlock, err := sivCiphers(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var v [sivBlockSize]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-sivBlockSize)
+	sivCTR(ctrBlock, v, plaintext, ciphertext[sivBlockSize:])
+
+	expected := s2v(macBlock, plaintext, ad)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		return nil, errSIVOpen
+	}
+	return plaintext, nil
+}
+
+// sivCiphers делит ключ пополам: первая половина для CMAC, вторая для CTR
+func sivCiphers(key []byte) (cipher.Block, cipher.Block, error) {
+	if len(key) != sivKeySize {
+		return nil, nil, errors.New("siv: invalid key size")
+	}
+	macBlock, err := aes.NewCipher(key[:sivKeySize/2])
+	if err != nil {
+		return nil, nil, err
+	}
+	ctrBlock, err := aes.NewCipher(key[sivKeySize/2:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return macBlock, ctrBlock, nil
+}
+
+// sivCTR шифрует (или расшифровывает) src в режиме CTR, начиная со счетчика из v,
+// в котором, по RFC 5297, обнулены 31-й и 63-й биты
+func sivCTR(block cipher.Block, v [sivBlockSize]byte, dst, src []byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(block, v[:]).XORKeyStream(dst, src)
+}
+
+// s2v строит синтетический вектор из связанных данных и открытого текста
+func s2v(block cipher.Block, plaintext []byte, ad [][]byte) [sivBlockSize]byte {
+	var zero [sivBlockSize]byte
+	d := cmac(block, zero[:])
+	for _, s := range ad {
+		d = dbl(d)
+		xorBlock(&d, cmac(block, s))
+	}
+
+	if len(plaintext) >= sivBlockSize {
+		t := append([]byte(nil), plaintext...)
+		tail := t[len(t)-sivBlockSize:]
+		for i := range tail {
+			tail[i] ^= d[i]
+		}
+		return cmac(block, t)
+	}
+
+	d = dbl(d)
+	xorBlock(&d, padBlock(plaintext))
+	return cmac(block, d[:])
+}
+
+// cmac вычисляет AES-CMAC (RFC 4493)
+func cmac(block cipher.Block, msg []byte) [sivBlockSize]byte {
+	var l [sivBlockSize]byte
+	block.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	n := (len(msg) + sivBlockSize - 1) / sivBlockSize
+	var last [sivBlockSize]byte
+	if n > 0 && len(msg)%sivBlockSize == 0 {
+		copy(last[:], msg[(n-1)*sivBlockSize:])
+		xorBlock(&last, k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		last = padBlock(msg[(n-1)*sivBlockSize:])
+		xorBlock(&last, k2)
+	}
+
+	var x [sivBlockSize]byte
+	for i := 0; i < n-1; i++ {
+		var m [sivBlockSize]byte
+		copy(m[:], msg[i*sivBlockSize:])
+		xorBlock(&x, m)
+		block.Encrypt(x[:], x[:])
+	}
+	xorBlock(&x, last)
+	block.Encrypt(x[:], x[:])
+
+	return x
+}
+
+// dbl умножает блок на x в поле GF(2^128)
+func dbl(b [sivBlockSize]byte) [sivBlockSize]byte {
+	var out [sivBlockSize]byte
+	carry := b[0] >> 7
+	for i := 0; i < sivBlockSize-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[sivBlockSize-1] = b[sivBlockSize-1] << 1
+	out[sivBlockSize-1] ^= 0x87 * carry
+	return out
+}
+
+// padBlock дополняет неполный блок битом 1 и нулями
+func padBlock(b []byte) [sivBlockSize]byte {
+	var out [sivBlockSize]byte
+	copy(out[:], b)
+	out[len(b)] = 0x80
+	return out
+}
+
+// xorBlock выполняет dst ^= src
+func xorBlock(dst *[sivBlockSize]byte, src [sivBlockSize]byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/beevik/ntp"
+)
+
+// TimeSource источник точного времени, с которым сравниваются локальные часы
+type TimeSource interface {
+	// Name возвращает короткое название источника для вывода
+	Name() string
+	// Time возвращает текущее время по данным источника
+	Time() (time.Time, error)
+}
+
+// selectionReporter реализуют источники, которые опрашивают несколько серверов
+// и могут сообщить, какие из них были отобраны
+type selectionReporter interface {
+	lastSelection() selection
+}
+
+// NTPSource получает время от NTP серверов, отбрасывая несогласных с большинством
+type NTPSource struct {
+	servers []string
+	query   queryFunc
+	clock   clock
+	last    selection
+}
+
+// NewNTPSource создает источник, опрашивающий servers функцией query
+func NewNTPSource(servers []string, query queryFunc) *NTPSource {
+	return &NTPSource{servers: servers, query: query, clock: systemClock{}}
+}
+
+// Name возвращает название источника
+func (s *NTPSource) Name() string {
+	return "NTP"
+}
+
+// Time опрашивает серверы и возвращает локальное время, исправленное на общее смещение
+func (s *NTPSource) Time() (time.Time, error) {
+	now := s.clock.Now()
+	selected, err := marzullo(queryServers(s.servers, s.query))
+	s.last = selected
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(selected.offset), nil
+}
+
+func (s *NTPSource) lastSelection() selection {
+	return s.last
+}
+
+// ntpQuery возвращает функцию опроса NTP сервера с заданными таймаутом и аутентификацией
+func ntpQuery(timeout time.Duration, auth ntp.AuthOptions) queryFunc {
+	return func(server string) (*ntp.Response, error) {
+		return ntp.QueryWithOptions(server, ntp.QueryOptions{Timeout: timeout, Auth: auth})
+	}
+}
+
+// newQuery создает функцию опроса по флагам: без аутентификации, с симметричным ключом
+// из keysFile или через NTS с доверенными сертификатами из caFile
+func newQuery(keysFile string, keyID uint, useNTS bool, caFile string, timeout time.Duration) (queryFunc, error) {
+	if useNTS {
+		if keysFile != "" {
+			return nil, errors.New("symmetric keys and NTS cannot be used together")
+		}
+		config := &tls.Config{}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", caFile)
+			}
+		}
+		return ntsQuery(config, timeout), nil
+	}
+
+	var auth ntp.AuthOptions
+	if keysFile != "" {
+		if keyID > math.MaxUint16 {
+			return nil, fmt.Errorf("invalid key id %d", keyID)
+		}
+		keys, err := readKeysFile(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := selectKey(keys, uint16(keyID))
+		if err != nil {
+			return nil, err
+		}
+		auth = key.authOptions()
+	}
+	return ntpQuery(timeout, auth), nil
+}
+
+// SystemSource отдает время локальных часов без коррекции
+type SystemSource struct{}
+
+// Name возвращает название источника
+func (SystemSource) Name() string {
+	return "System"
+}
+
+// Time возвращает время локальных часов
+func (SystemSource) Time() (time.Time, error) {
+	return time.Now(), nil
+}
+
+// HTTPSource получает время из заголовка Date ответа HTTP сервера.
+// Точность ограничена секундой, но такой источник доступен там, где закрыт UDP
+type HTTPSource struct {
+	url    string
+	client *http.Client
+	clock  clock
+}
+
+// NewHTTPSource создает источник, запрашивающий url с таймаутом timeout
+func NewHTTPSource(url string, timeout time.Duration) *HTTPSource {
+	return &HTTPSource{url: url, client: &http.Client{Timeout: timeout}, clock: systemClock{}}
+}
+
+// Name возвращает название источника
+func (s *HTTPSource) Name() string {
+	return "HTTP"
+}
+
+// Time выполняет HEAD запрос и возвращает время из заголовка Date,
+// поправленное на половину времени прохождения запроса
+func (s *HTTPSource) Time() (time.Time, error) {
+	sent := s.clock.Now()
+	response, err := s.client.Head(s.url)
+	if err != nil {
+		return time.Time{}, err
+	}
+	response.Body.Close()
+	received := s.clock.Now()
+
+	date := response.Header.Get("Date")
+	if date == "" {
+		return time.Time{}, errors.New("response has no Date header")
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid Date header %q: %v", date, err)
+	}
+	// Сервер отвечает примерно в середине интервала между отправкой и получением
+	return serverTime.Add(received.Sub(sent) / 2), nil
+}
+
+// FixedSource всегда возвращает заданное время или ошибку. Используется в тестах
+// и для имитации источника с известным смещением
+type FixedSource struct {
+	T   time.Time
+	Err error
+}
+
+// Name возвращает название источника
+func (FixedSource) Name() string {
+	return "Fixed"
+}
+
+// Time возвращает заданные время и ошибку
+func (s FixedSource) Time() (time.Time, error) {
+	return s.T, s.Err
+}
+
+// newTimeSource создает источник по имени из флага -source
+func newTimeSource(kind string, servers []string, url, fixed string, timeout time.Duration, query queryFunc) (TimeSource, error) {
+	switch kind {
+	case "ntp":
+		if len(servers) == 0 {
+			return nil, errors.New("no NTP servers specified")
+		}
+		return NewNTPSource(servers, query), nil
+	case "system":
+		return SystemSource{}, nil
+	case "http":
+		if url == "" {
+			return nil, errors.New("no URL specified for HTTP source")
+		}
+		return NewHTTPSource(url, timeout), nil
+	case "fixed":
+		t, err := time.Parse(time.RFC3339Nano, fixed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed time: %v", err)
+		}
+		return FixedSource{T: t}, nil
+	}
+	return nil, fmt.Errorf("unknown time source %q", kind)
+}
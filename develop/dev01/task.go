@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
-
-	"github.com/beevik/ntp"
 )
 
 /*
@@ -20,34 +24,260 @@ import (
 Программа должна корректно обрабатывать ошибки библиотеки: распечатывать их в STDERR и возвращать ненулевой код выхода в OS.
 Программа должна проходить проверки go vet и golint.
 */
+// Серверы, опрашиваемые по умолчанию
+const defaultServers = "pool.ntp.org"
+
 // Структура для хранения локального времени и времени NTP
 type currentTime struct {
 	timePackage string
 	ntpPackage  string
+	// Название источника точного времени
+	source string
+	// Локальное время и время по данным источника
+	local time.Time
+	exact time.Time
+	// Итоговое смещение локальных часов и результат отбора серверов
+	offset       time.Duration
+	truechimers  []string
+	falsechimers []string
+	failed       []string
 }
 
-func printCurrentTime() (currentTime, error) {
+func printCurrentTime(local clock, source TimeSource) (currentTime, error) {
 	// Инициализируем структуру и, сразу, указываем локальное время
-	nowTime := currentTime{timePackage: time.Now().UTC().Format(time.RFC3339)}
+	now := local.Now()
+	nowTime := currentTime{timePackage: now.UTC().Format(time.RFC3339), source: source.Name(), local: now}
 	// Делаем запрос точного времени и, при возникновении ошибки, возвращаем ее
-	ntpTime, err := ntp.Time("pool.ntp.org")
+	sourceTime, err := source.Time()
+	// Источники из нескольких серверов сообщают результат отбора даже при ошибке
+	if reporter, ok := source.(selectionReporter); ok {
+		selected := reporter.lastSelection()
+		nowTime.truechimers = selected.truechimers
+		nowTime.falsechimers = selected.falsechimers
+		nowTime.failed = selected.failed
+	}
 	if err != nil {
-		return nowTime, fmt.Errorf("failed to get NTP time: %v", err)
+		return nowTime, fmt.Errorf("failed to get %s time: %v", source.Name(), err)
 	}
 	// Если все прошло хорошо - записываем точное время в структуру и возвращаем ее.
-	nowTime.ntpPackage = ntpTime.UTC().Format(time.RFC3339)
+	nowTime.ntpPackage = sourceTime.UTC().Format(time.RFC3339)
+	nowTime.exact = sourceTime
+	nowTime.offset = sourceTime.Sub(now)
 
 	return nowTime, nil
 }
 
+// writeCurrentTime выводит результат в человекочитаемом виде
+func writeCurrentTime(w io.Writer, nowInfo currentTime) error {
+	if _, err := fmt.Fprintf(w, "Local Time: %s\n%s Time: %s\nOffset: %v\n",
+		nowInfo.timePackage, nowInfo.source, nowInfo.ntpPackage, nowInfo.offset); err != nil {
+		return err
+	}
+	if len(nowInfo.truechimers) > 0 {
+		if _, err := fmt.Fprintf(w, "Agreed servers: %s\n", strings.Join(nowInfo.truechimers, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(nowInfo.falsechimers) > 0 {
+		if _, err := fmt.Fprintf(w, "Rejected servers: %s\n", strings.Join(nowInfo.falsechimers, ", ")); err != nil {
+			return err
+		}
+	}
+	if len(nowInfo.failed) > 0 {
+		if _, err := fmt.Fprintf(w, "Unreachable servers: %s\n", strings.Join(nowInfo.failed, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitServers разбирает список серверов, перечисленных через запятую
+func splitServers(list string) []string {
+	var servers []string
+	for _, server := range strings.Split(list, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// runDiagnostics выводит отчеты по серверам и возвращает код выхода:
+// ненулевой, если ни один сервер не дал пригодного ответа
+func runDiagnostics(w io.Writer, servers []string, query queryFunc, asJSON bool) int {
+	reports := diagnoseServers(servers, query)
+	if err := writeDiagnostics(w, reports, asJSON); err != nil {
+		log.Printf("Error: %v\n", err)
+		return 1
+	}
+	for _, report := range reports {
+		if report.Valid {
+			return 0
+		}
+	}
+	return 1
+}
+
+// resolveOutput возвращает формат вывода по флагам -output и -json. -json - синоним
+// -output json и не может сочетаться с другим форматом, а диагностика выводится
+// только текстом или в JSON
+func resolveOutput(output string, outputSet, asJSON, diag bool) (string, error) {
+	if asJSON {
+		if outputSet && output != "json" {
+			return "", fmt.Errorf("-json conflicts with -output %s", output)
+		}
+		output = "json"
+	}
+	if output != "text" && output != "json" && output != "line" {
+		return "", fmt.Errorf("unknown output format %q", output)
+	}
+	if diag && output == "line" {
+		return "", errors.New("diagnostics support text and json output only")
+	}
+	return output, nil
+}
+
 func main() {
+	sourceFlag := flag.String("source", "ntp", "Time source: ntp, system, http or fixed")
+	serversFlag := flag.String("servers", defaultServers, "Comma-separated list of NTP servers")
+	urlFlag := flag.String("url", "", "URL whose Date header is used by the http source")
+	fixedFlag := flag.String("fixed", "", "RFC3339 time returned by the fixed source")
+	timeoutFlag := flag.Duration("timeout", 5*time.Second, "Timeout of a single query")
+	diagFlag := flag.Bool("diag", false, "Print full NTP diagnostics for every server")
+	jsonFlag := flag.Bool("json", false, "Alias for -output json")
+	watchFlag := flag.Bool("watch", false, "Poll servers continuously and report clock drift")
+	intervalFlag := flag.Duration("interval", time.Minute, "Polling interval in watch mode")
+	historyFlag := flag.Int("history", 30, "Number of offsets kept for drift estimation in watch mode")
+	thresholdFlag := flag.Duration("threshold", 0, "Alert when absolute offset exceeds this value in watch mode")
+	exitOnAlertFlag := flag.Bool("exit-on-alert", false, "Exit with non-zero status on the first alert in watch mode")
+	countFlag := flag.Int("count", 0, "Number of polls in watch mode, 0 means unlimited")
+	serveFlag := flag.String("serve", "", "Serve SNTP on the given UDP address instead of querying servers")
+	serveOffsetFlag := flag.Duration("serve-offset", 0, "Offset added to the local clock in server mode")
+	keysFlag := flag.String("keys", "", "ntpd-style keys file for symmetric-key authentication")
+	keyIDFlag := flag.Uint("keyid", 0, "Key ID from the keys file, 0 means the first key")
+	ntsFlag := flag.Bool("nts", false, "Treat servers as NTS-KE servers and authenticate with NTS")
+	ntsCAFlag := flag.String("nts-ca", "", "PEM file with CA certificates trusted for NTS-KE")
+	serveKeysFlag := flag.String("serve-keys", "", "Keys file used to verify authenticated requests in server mode")
+	serveNTSKEFlag := flag.String("serve-nts-ke", "", "Serve NTS-KE on the given TCP address in server mode")
+	tlsCertFlag := flag.String("tls-cert", "", "TLS certificate for the NTS-KE server")
+	tlsKeyFlag := flag.String("tls-key", "", "TLS private key for the NTS-KE server")
+	setFlag := flag.Bool("set", false, "Correct the local clock by the measured offset")
+	stepThresholdFlag := flag.Duration("step-threshold", defaultStepThreshold, "Offsets from this value are stepped, smaller ones are slewed")
+	maxOffsetFlag := flag.Duration("max-offset", defaultMaxOffset, "Refuse to correct offsets larger than this value, 0 means no limit")
+	dryRunFlag := flag.Bool("dry-run", false, "Print the clock syscall instead of making it")
+	formatFlag := flag.String("format", "rfc3339", "Time layout: rfc3339, rfc3339nano, rfc1123, unix, unixms, unixns, a Go layout or a strftime pattern")
+	tzFlag := flag.String("tz", "UTC", "IANA time zone used to print times")
+	outputFlag := flag.String("output", "text", "Output format: text, json or line; diagnostics support text and json")
+	flag.Parse()
+
+	outputSet := false
+	flag.Visit(func(f *flag.Flag) {
+		outputSet = outputSet || f.Name == "output"
+	})
+	output, err := resolveOutput(*outputFlag, outputSet, *jsonFlag, *diagFlag)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// В режиме сервера отвечаем на запросы временем локальных часов, возможно сдвинутым
+	if *serveFlag != "" {
+		err := runServer(serverConfig{
+			addr:      *serveFlag,
+			offset:    *serveOffsetFlag,
+			keysFile:  *serveKeysFlag,
+			ntsKEAddr: *serveNTSKEFlag,
+			certFile:  *tlsCertFlag,
+			keyFile:   *tlsKeyFlag,
+		})
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	servers := splitServers(*serversFlag)
+	query, err := newQuery(*keysFlag, *keyIDFlag, *ntsFlag, *ntsCAFlag, *timeoutFlag)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if (*diagFlag || *watchFlag) && len(servers) == 0 {
+		log.Printf("Error: no NTP servers specified\n")
+		os.Exit(1)
+	}
+	// В режиме диагностики выводим полный ответ каждого сервера
+	if *diagFlag {
+		os.Exit(runDiagnostics(os.Stdout, servers, query, output == "json"))
+	}
+	// В режиме наблюдения опрашиваем серверы, пока не придет сигнал завершения
+	if *watchFlag {
+		if *intervalFlag <= 0 {
+			log.Printf("Error: interval must be positive\n")
+			os.Exit(1)
+		}
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+
+		cfg := watchConfig{
+			history:     *historyFlag,
+			threshold:   *thresholdFlag,
+			exitOnAlert: *exitOnAlertFlag,
+			count:       *countFlag,
+		}
+		os.Exit(runWatch(os.Stdout, servers, query, cfg, watchTicks(*intervalFlag, stop)))
+	}
+	timeFormat, err := parseTimeFormat(*formatFlag, *tzFlag)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *setFlag {
+		if err := checkClockSource(*sourceFlag); err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	source, err := newTimeSource(*sourceFlag, servers, *urlFlag, *fixedFlag, *timeoutFlag, query)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
 	// Вызываем функцию
-	nowInfo, err := printCurrentTime()
+	nowInfo, err := printCurrentTime(systemClock{}, source)
 	// Проверяем на ошибку, если есть ошибка - заканчиваем работу со статусом 1
 	if err != nil {
 		log.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 	// Выводим время
-	fmt.Printf("Local Time: %s\nNTP Time: %s\n", nowInfo.timePackage, nowInfo.ntpPackage)
+	if err := writeTimeReport(os.Stdout, nowInfo, timeFormat, output); err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	// По запросу исправляем локальные часы на измеренное смещение
+	if *setFlag {
+		var adjuster clockAdjuster = systemAdjuster{}
+		if *dryRunFlag {
+			adjuster = dryRunAdjuster{w: os.Stdout, clock: systemClock{}}
+		}
+		policy := clockPolicy{stepThreshold: *stepThresholdFlag, maxOffset: *maxOffsetFlag}
+		action, err := correctClock(adjuster, nowInfo.offset, policy)
+		if err != nil {
+			log.Printf("Error: failed to set clock: %v\n", err)
+			os.Exit(1)
+		}
+		// Машиночитаемый вывод не смешиваем с отчетом о коррекции
+		if output == "text" {
+			fmt.Printf("Clock correction: %s\n", action)
+		} else {
+			log.Printf("Clock correction: %s\n", action)
+		}
+	}
 }
@@ -1,13 +1,46 @@
 package main
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/beevik/ntp"
 )
 
+// startServer запускает локальный SNTP сервер и останавливает его по завершении теста
+func startServer(t *testing.T, c clock) string {
+	t.Helper()
+	server, err := listenSNTP("127.0.0.1:0", c)
+	if err != nil {
+		t.Fatalf("Failed to start SNTP server: %v", err)
+	}
+	go server.serve()
+	t.Cleanup(func() { server.close() })
+	return server.addr().String()
+}
+
 func TestPrintCurrentTimeFormat(t *testing.T) {
-	// Вызываем тестируемую функцию
-	nowInfo, err := printCurrentTime()
+	// Вызываем тестируемую функцию, опрашивая локальный сервер вместо pool.ntp.org
+	nowInfo, err := printCurrentTime(systemClock{}, NewNTPSource([]string{startServer(t, systemClock{})}, ntpQuery(time.Second, ntp.AuthOptions{})))
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
@@ -22,3 +55,1095 @@ func TestPrintCurrentTimeFormat(t *testing.T) {
 		t.Errorf("Expected time in RFC3339 format, got: %s", nowInfo.ntpPackage)
 	}
 }
+
+// fakeResponse создает валидный ответ NTP сервера с заданным смещением и погрешностью
+func fakeResponse(offset, distance time.Duration) *ntp.Response {
+	now := time.Now()
+	return &ntp.Response{
+		Time:          now,
+		ReferenceTime: now.Add(-time.Minute),
+		ClockOffset:   offset,
+		RootDistance:  distance,
+		Stratum:       2,
+	}
+}
+
+// fakeQuery возвращает функцию опроса, отвечающую заранее заданными ответами
+func fakeQuery(responses map[string]*ntp.Response) queryFunc {
+	return func(server string) (*ntp.Response, error) {
+		response, ok := responses[server]
+		if !ok {
+			return nil, errors.New("no such host")
+		}
+		return response, nil
+	}
+}
+
+func TestMarzulloRejectsFalsechimer(t *testing.T) {
+	query := fakeQuery(map[string]*ntp.Response{
+		"a": fakeResponse(100*time.Millisecond, 20*time.Millisecond),
+		"b": fakeResponse(110*time.Millisecond, 20*time.Millisecond),
+		"c": fakeResponse(95*time.Millisecond, 30*time.Millisecond),
+		"d": fakeResponse(5*time.Second, 20*time.Millisecond),
+	})
+
+	selected, err := marzullo(queryServers([]string{"a", "b", "c", "d", "e"}, query))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(selected.truechimers, []string{"a", "b", "c"}) {
+		t.Errorf("Unexpected truechimers: %v", selected.truechimers)
+	}
+	if !reflect.DeepEqual(selected.falsechimers, []string{"d"}) {
+		t.Errorf("Unexpected falsechimers: %v", selected.falsechimers)
+	}
+	if !reflect.DeepEqual(selected.failed, []string{"e"}) {
+		t.Errorf("Unexpected failed servers: %v", selected.failed)
+	}
+	// Пересечение [90ms, 120ms] общее для a, b и c
+	if selected.low != 90*time.Millisecond || selected.high != 120*time.Millisecond {
+		t.Errorf("Unexpected intersection: [%v, %v]", selected.low, selected.high)
+	}
+	if selected.offset != 105*time.Millisecond {
+		t.Errorf("Unexpected offset: %v", selected.offset)
+	}
+}
+
+func TestMarzulloNoMajority(t *testing.T) {
+	query := fakeQuery(map[string]*ntp.Response{
+		"a": fakeResponse(0, 10*time.Millisecond),
+		"b": fakeResponse(time.Second, 10*time.Millisecond),
+	})
+
+	_, err := marzullo(queryServers([]string{"a", "b"}, query))
+	if !errors.Is(err, errNoMajority) {
+		t.Errorf("Expected errNoMajority, got: %v", err)
+	}
+}
+
+func TestMarzulloAllFailed(t *testing.T) {
+	source := &NTPSource{servers: []string{"a", "b"}, query: fakeQuery(nil), clock: systemClock{}}
+	nowInfo, err := printCurrentTime(systemClock{}, source)
+	if err == nil {
+		t.Fatal("Expected error when all servers fail")
+	}
+	if !reflect.DeepEqual(nowInfo.failed, []string{"a", "b"}) {
+		t.Errorf("Unexpected failed servers: %v", nowInfo.failed)
+	}
+}
+
+func TestSplitServers(t *testing.T) {
+	got := splitServers(" a.example, ,b.example:123,")
+	want := []string{"a.example", "b.example:123"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitServers() = %v, want %v", got, want)
+	}
+}
+
+func TestDiagnosticsText(t *testing.T) {
+	response := fakeResponse(25*time.Millisecond, 10*time.Millisecond)
+	response.RTT = 40 * time.Millisecond
+	response.Stratum = 1
+	response.ReferenceID = 0x47505300 // "GPS"
+	response.RootDispersion = 3 * time.Millisecond
+	response.Leap = ntp.LeapAddSecond
+
+	var out bytes.Buffer
+	code := runDiagnostics(&out, []string{"a", "b"}, fakeQuery(map[string]*ntp.Response{"a": response}), false)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+
+	for _, want := range []string{
+		"Server: a\n",
+		"Clock Offset: 25ms\n",
+		"Round-Trip Delay: 40ms\n",
+		"Stratum: 1\n",
+		"Reference ID: .GPS.\n",
+		"Root Dispersion: 3ms\n",
+		"Leap Indicator: add second\n",
+		"Status: valid\n",
+		"Server: b\nError: no such host\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestDiagnosticsKissOfDeathJSON(t *testing.T) {
+	response := fakeResponse(0, 0)
+	response.Stratum = 0
+	response.ReferenceID = 0x52415445 // "RATE"
+	response.KissCode = "RATE"
+
+	var out bytes.Buffer
+	code := runDiagnostics(&out, []string{"a"}, fakeQuery(map[string]*ntp.Response{"a": response}), true)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 for kiss of death, got %d", code)
+	}
+
+	var reports []diagnostics
+	if err := json.Unmarshal(out.Bytes(), &reports); err != nil {
+		t.Fatalf("Invalid JSON output: %v", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(reports))
+	}
+	report := reports[0]
+	if !report.KissOfDeath || report.KissCode != "RATE" || report.Valid {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if report.ValidationError != ntp.ErrKissOfDeath.Error() {
+		t.Errorf("Unexpected validation error: %q", report.ValidationError)
+	}
+}
+
+func TestDriftMonitorPPM(t *testing.T) {
+	monitor := newDriftMonitor(3)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, ok := monitor.driftPPM(); ok {
+		t.Error("Expected no drift estimate without measurements")
+	}
+
+	// Первое измерение выпадает из истории, остальные дрейфуют на 10мкс в секунду, т.е. 10ppm
+	monitor.add(offsetPoint{at: start, offset: time.Second})
+	for i := 1; i <= 3; i++ {
+		monitor.add(offsetPoint{at: start.Add(time.Duration(i) * 100 * time.Second), offset: time.Duration(i) * time.Millisecond})
+	}
+	if len(monitor.history) != 3 {
+		t.Fatalf("Expected history of 3, got %d", len(monitor.history))
+	}
+
+	ppm, ok := monitor.driftPPM()
+	if !ok {
+		t.Fatal("Expected drift estimate")
+	}
+	if ppm < 9.999 || ppm > 10.001 {
+		t.Errorf("Expected drift of 10ppm, got %f", ppm)
+	}
+}
+
+func TestRunWatchAlert(t *testing.T) {
+	offsets := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 300 * time.Millisecond, 0}
+	polls := 0
+	query := func(server string) (*ntp.Response, error) {
+		response := fakeResponse(offsets[polls], time.Millisecond)
+		polls++
+		return response, nil
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ticks := make(chan time.Time, len(offsets))
+	for i := range offsets {
+		ticks <- start.Add(time.Duration(i) * time.Second)
+	}
+	close(ticks)
+
+	var out bytes.Buffer
+	cfg := watchConfig{history: 10, threshold: 100 * time.Millisecond, exitOnAlert: true}
+	code := runWatch(&out, []string{"a"}, query, cfg, ticks)
+
+	if code != alertExitCode {
+		t.Errorf("Expected exit code %d, got %d", alertExitCode, code)
+	}
+	if polls != 3 {
+		t.Errorf("Expected watch to stop after 3 polls, got %d", polls)
+	}
+
+	want := "2024-01-01T00:00:00Z offset=10ms drift=n/a agreed=1/1\n" +
+		"2024-01-01T00:00:01Z offset=20ms drift=+10000.000ppm agreed=1/1\n" +
+		"2024-01-01T00:00:02Z offset=300ms drift=+145000.000ppm agreed=1/1\n" +
+		"2024-01-01T00:00:02Z ALERT: offset 300ms exceeds threshold 100ms\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestRunWatchCount(t *testing.T) {
+	ticks := make(chan time.Time, 5)
+	for i := 0; i < 5; i++ {
+		ticks <- time.Unix(int64(i), 0)
+	}
+
+	var out bytes.Buffer
+	query := fakeQuery(map[string]*ntp.Response{"a": fakeResponse(500*time.Millisecond, time.Millisecond)})
+	code := runWatch(&out, []string{"a", "b"}, query, watchConfig{threshold: time.Second, count: 2}, ticks)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 2 {
+		t.Errorf("Expected 2 lines, got %d:\n%s", n, out.String())
+	}
+	if strings.Contains(out.String(), "ALERT") {
+		t.Errorf("Unexpected alert:\n%s", out.String())
+	}
+}
+
+func TestServerOffsetClock(t *testing.T) {
+	skew := 2 * time.Hour
+	addr := startServer(t, offsetClock{base: systemClock{}, offset: skew})
+
+	response, err := ntp.Query(addr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := response.Validate(); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	if response.Stratum != serverStratum || response.ReferenceString() != ".LOCL." {
+		t.Errorf("Unexpected stratum %d or reference %s", response.Stratum, response.ReferenceString())
+	}
+	if d := abs(response.ClockOffset - skew); d > time.Second {
+		t.Errorf("Expected offset about %v, got %v", skew, response.ClockOffset)
+	}
+}
+
+func TestServerIgnoresInvalidRequests(t *testing.T) {
+	server := &sntpServer{clock: systemClock{}}
+	request := make([]byte, packetSize)
+
+	// Слишком короткий пакет
+	if _, ok := server.respond(request[:10], time.Now()); ok {
+		t.Error("Expected short packet to be ignored")
+	}
+	// Пакет в режиме сервера, а не клиента
+	request[0] = 4<<3 | modeServer
+	if _, ok := server.respond(request, time.Now()); ok {
+		t.Error("Expected server-mode packet to be ignored")
+	}
+	// Корректный запрос: исходная метка ответа равна метке отправки запроса
+	request[0] = 4<<3 | modeClient
+	copy(request[40:], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	response, ok := server.respond(request, time.Now())
+	if !ok {
+		t.Fatal("Expected client request to be answered")
+	}
+	if !bytes.Equal(response[24:32], request[40:48]) {
+		t.Errorf("Origin timestamp mismatch: %v", response[24:32])
+	}
+}
+
+func TestToNtpTime(t *testing.T) {
+	got := toNtpTime(time.Date(1970, 1, 1, 0, 0, 0, int(time.Second/2), time.UTC))
+	want := uint64(ntpEpochOffset)<<32 | 1<<31
+	if got != want {
+		t.Errorf("toNtpTime() = %x, want %x", got, want)
+	}
+}
+
+// fakeClock часы, всегда показывающие одно и то же время
+type fakeClock struct {
+	t time.Time
+}
+
+func (c fakeClock) Now() time.Time {
+	return c.t
+}
+
+func TestPrintCurrentTimeFixedSource(t *testing.T) {
+	local := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	source := FixedSource{T: local.Add(90 * time.Second)}
+
+	nowInfo, err := printCurrentTime(fakeClock{local}, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeCurrentTime(&out, nowInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "Local Time: 2024-03-01T12:00:00Z\nFixed Time: 2024-03-01T12:01:30Z\nOffset: 1m30s\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestPrintCurrentTimeSourceError(t *testing.T) {
+	source := FixedSource{Err: errors.New("boom")}
+	nowInfo, err := printCurrentTime(systemClock{}, source)
+	if err == nil || err.Error() != "failed to get Fixed time: boom" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if nowInfo.ntpPackage != "" {
+		t.Errorf("Expected empty source time on error, got %s", nowInfo.ntpPackage)
+	}
+}
+
+func TestPrintCurrentTimeNTPSelection(t *testing.T) {
+	local := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	source := &NTPSource{
+		servers: []string{"a", "b", "c"},
+		query: fakeQuery(map[string]*ntp.Response{
+			"a": fakeResponse(2*time.Second, 10*time.Millisecond),
+			"b": fakeResponse(2*time.Second, 10*time.Millisecond),
+			"c": fakeResponse(-time.Hour, 10*time.Millisecond),
+		}),
+		clock: fakeClock{local},
+	}
+
+	nowInfo, err := printCurrentTime(fakeClock{local}, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeCurrentTime(&out, nowInfo); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "Local Time: 2024-03-01T12:00:00Z\nNTP Time: 2024-03-01T12:00:02Z\nOffset: 2s\n" +
+		"Agreed servers: a, b\nRejected servers: c\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestNTPSourceTimeout(t *testing.T) {
+	// Сервер, который никогда не отвечает
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	started := time.Now()
+	_, err = NewNTPSource([]string{conn.LocalAddr().String()}, ntpQuery(50*time.Millisecond, ntp.AuthOptions{})).Time()
+	if err == nil {
+		t.Fatal("Expected timeout error")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Timeout took too long: %v", elapsed)
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", "Fri, 01 Mar 2024 12:00:00 GMT")
+	}))
+	defer server.Close()
+
+	source := NewHTTPSource(server.URL, time.Second)
+	source.clock = fakeClock{time.Now()}
+	got, err := source.Time()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Time() = %v, want %v", got, want)
+	}
+}
+
+func TestHTTPSourceErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	if _, err := NewHTTPSource(slow.URL, 20*time.Millisecond).Time(); err == nil {
+		t.Error("Expected timeout error")
+	}
+
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", "yesterday")
+	}))
+	defer invalid.Close()
+	if _, err := NewHTTPSource(invalid.URL, time.Second).Time(); err == nil || !strings.Contains(err.Error(), "invalid Date header") {
+		t.Errorf("Expected invalid Date error, got: %v", err)
+	}
+}
+
+func TestNewTimeSource(t *testing.T) {
+	tests := []struct {
+		kind    string
+		servers []string
+		url     string
+		fixed   string
+		name    string
+		wantErr bool
+	}{
+		{"ntp", []string{"a"}, "", "", "NTP", false},
+		{"ntp", nil, "", "", "", true},
+		{"system", nil, "", "", "System", false},
+		{"http", nil, "http://example.com", "", "HTTP", false},
+		{"http", nil, "", "", "", true},
+		{"fixed", nil, "", "2024-03-01T12:00:00Z", "Fixed", false},
+		{"fixed", nil, "", "noon", "", true},
+		{"sundial", nil, "", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.kind, func(t *testing.T) {
+			source, err := newTimeSource(test.kind, test.servers, test.url, test.fixed, time.Second, fakeQuery(nil))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error status: got %v, want %v", err, test.wantErr)
+			}
+			if err == nil && source.Name() != test.name {
+				t.Errorf("Name() = %s, want %s", source.Name(), test.name)
+			}
+		})
+	}
+}
+
+// decodeHex декодирует шестнадцатеричную строку, пропуская пробелы
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestCMAC(t *testing.T) {
+	// Примеры из RFC 4493, раздел 4
+	block, err := aes.NewCipher(decodeHex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := decodeHex(t, "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51"+
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710")
+
+	// Подключи K1 и K2 из L = AES(K, 0)
+	var l [sivBlockSize]byte
+	block.Encrypt(l[:], l[:])
+	k1 := dbl(l)
+	k2 := dbl(k1)
+	if got := hex.EncodeToString(k1[:]); got != "fbeed618357133667c85e08f7236a8de" {
+		t.Errorf("K1 = %s", got)
+	}
+	if got := hex.EncodeToString(k2[:]); got != "f7ddac306ae266ccf90bc11ee46d513b" {
+		t.Errorf("K2 = %s", got)
+	}
+
+	tests := []struct {
+		length int
+		want   string
+	}{
+		{0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, test := range tests {
+		if got := cmac(block, message[:test.length]); !bytes.Equal(got[:], decodeHex(t, test.want)) {
+			t.Errorf("CMAC of %d bytes = %x, want %s", test.length, got, test.want)
+		}
+	}
+}
+
+func TestSIV(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		ad         []string
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			// RFC 5297, A.1: детерминированное шифрование
+			name:       "A.1",
+			key:        "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+			ad:         []string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+			plaintext:  "11223344 55667788 99aabbcc ddee",
+			ciphertext: "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+		},
+		{
+			// RFC 5297, A.2: несколько компонент связанных данных, последняя - nonce
+			name: "A.2",
+			key:  "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+			ad: []string{
+				"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+				"10203040 50607080 90a0",
+				"09f91102 9d74e35b d84156c5 635688c0",
+			},
+			plaintext: "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970" +
+				"74207573 696e6720 5349562d 414553",
+			ciphertext: "7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17" +
+				"dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := decodeHex(t, test.key)
+			plaintext := decodeHex(t, test.plaintext)
+			var ad [][]byte
+			for _, a := range test.ad {
+				ad = append(ad, decodeHex(t, a))
+			}
+
+			ciphertext, err := sivSeal(key, plaintext, ad...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if want := decodeHex(t, test.ciphertext); !bytes.Equal(ciphertext, want) {
+				t.Fatalf("sivSeal() = %x, want %x", ciphertext, want)
+			}
+			opened, err := sivOpen(key, ciphertext, ad...)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("sivOpen() = %x, %v", opened, err)
+			}
+
+			// Любое изменение тега, шифротекста или связанных данных обнаруживается
+			for i := range ciphertext {
+				tampered := append([]byte(nil), ciphertext...)
+				tampered[i] ^= 0x40
+				if _, err := sivOpen(key, tampered, ad...); !errors.Is(err, errSIVOpen) {
+					t.Fatalf("Expected errSIVOpen for byte %d changed, got: %v", i, err)
+				}
+			}
+			for i := range ad {
+				tamperedAD := append([][]byte(nil), ad...)
+				tamperedAD[i] = append(append([]byte(nil), ad[i]...), 0)
+				if _, err := sivOpen(key, ciphertext, tamperedAD...); !errors.Is(err, errSIVOpen) {
+					t.Errorf("Expected errSIVOpen for changed AD %d, got: %v", i, err)
+				}
+			}
+			if _, err := sivOpen(key, ciphertext, ad[:len(ad)-1]...); !errors.Is(err, errSIVOpen) {
+				t.Errorf("Expected errSIVOpen for missing AD, got: %v", err)
+			}
+			if _, err := sivOpen(key, ciphertext[:sivBlockSize-1], ad...); !errors.Is(err, errSIVOpen) {
+				t.Errorf("Expected errSIVOpen for truncated ciphertext, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestSIVRoundTrip проверяет шифрование пустого и невыровненного по блоку текста
+func TestSIVRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, sivKeySize)
+	for _, length := range []int{0, 1, 15, 16, 17, 31, 32, 33, 100} {
+		plaintext := bytes.Repeat([]byte{byte(length)}, length)
+		for _, ad := range [][][]byte{nil, {nil}, {[]byte("header"), make([]byte, 17)}} {
+			ciphertext, err := sivSeal(key, plaintext, ad...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(ciphertext) != sivBlockSize+length {
+				t.Errorf("len = %d, want %d", len(ciphertext), sivBlockSize+length)
+			}
+			opened, err := sivOpen(key, ciphertext, ad...)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("length %d, %d AD: sivOpen() = %x, %v", length, len(ad), opened, err)
+			}
+			ciphertext[0] ^= 1
+			if _, err := sivOpen(key, ciphertext, ad...); !errors.Is(err, errSIVOpen) {
+				t.Errorf("length %d: expected errSIVOpen for tampered tag, got: %v", length, err)
+			}
+		}
+	}
+	if _, err := sivSeal(key[:16], nil); err == nil {
+		t.Error("Expected error for short key")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := parseKeys(strings.NewReader(`# ntp.keys
+1 MD5 secret # комментарий
+
+2 SHA1 0123456789abcdef0123456789abcdef01234567
+`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(keys) != 2 || keys[0].id != 1 || keys[0].algo != ntp.AuthMD5 || keys[1].algo != ntp.AuthSHA1 {
+		t.Fatalf("Unexpected keys: %+v", keys)
+	}
+	if len(keys[1].decoded) != 20 {
+		t.Errorf("Expected hex key to be decoded, got %d bytes", len(keys[1].decoded))
+	}
+
+	key, err := selectKey(keys, 0)
+	if err != nil || key.id != 1 {
+		t.Errorf("selectKey(0) = %+v, %v", key, err)
+	}
+	if _, err := selectKey(keys, 3); err == nil {
+		t.Error("Expected error for unknown key id")
+	}
+
+	for _, input := range []string{"1 MD5", "0 MD5 secret", "1 SHA256 secret", "1 MD5 abc", "1 SHA1 zz23456789abcdef0123456789"} {
+		if _, err := parseKeys(strings.NewReader(input)); err == nil {
+			t.Errorf("Expected error for %q", input)
+		}
+	}
+}
+
+func TestSymmetricKeyAuthentication(t *testing.T) {
+	serverKeys, err := parseKeys(strings.NewReader("1 MD5 secret\n2 SHA1 0123456789abcdef0123456789abcdef01234567\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	server, err := listenSNTP("127.0.0.1:0", systemClock{})
+	if err != nil {
+		t.Fatalf("Failed to start SNTP server: %v", err)
+	}
+	server.keys = serverKeys
+	go server.serve()
+	defer server.close()
+	addr := server.addr().String()
+
+	tests := []struct {
+		name    string
+		auth    ntp.AuthOptions
+		wantErr error
+	}{
+		{"md5", ntp.AuthOptions{Type: ntp.AuthMD5, Key: "secret", KeyID: 1}, nil},
+		{"sha1", ntp.AuthOptions{Type: ntp.AuthSHA1, Key: "0123456789abcdef0123456789abcdef01234567", KeyID: 2}, nil},
+		{"wrong key", ntp.AuthOptions{Type: ntp.AuthMD5, Key: "not-secret", KeyID: 1}, ntp.ErrAuthFailed},
+		{"unknown key id", ntp.AuthOptions{Type: ntp.AuthMD5, Key: "secret", KeyID: 7}, ntp.ErrAuthFailed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := ntpQuery(time.Second, test.auth)(addr)
+			if err != nil {
+				t.Fatalf("Unexpected query error: %v", err)
+			}
+			if err := response.Validate(); err != test.wantErr {
+				t.Errorf("Validate() = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// startNTSServers запускает NTS-KE и SNTP сервер с общим хранилищем cookie
+// и возвращает адрес NTS-KE и настройки TLS клиента, доверяющие сертификату сервера
+func startNTSServers(t *testing.T) (string, *tls.Config) {
+	t.Helper()
+	certificate, pool := selfSignedCertificate(t)
+
+	server, err := listenSNTP("127.0.0.1:0", systemClock{})
+	if err != nil {
+		t.Fatalf("Failed to start SNTP server: %v", err)
+	}
+	if server.jar, err = newCookieJar(); err != nil {
+		t.Fatalf("Failed to create cookie jar: %v", err)
+	}
+	go server.serve()
+	t.Cleanup(func() { server.close() })
+
+	ke, err := listenNTSKE("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{certificate}}, server.jar)
+	if err != nil {
+		t.Fatalf("Failed to start NTS-KE server: %v", err)
+	}
+	ke.ntpServer = "127.0.0.1"
+	ke.ntpPort = uint16(server.addr().(*net.UDPAddr).Port)
+	go ke.serve()
+	t.Cleanup(func() { ke.close() })
+
+	return ke.addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"}
+}
+
+// selfSignedCertificate создает самоподписанный сертификат для localhost
+func selfSignedCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool
+}
+
+func TestNTSQuery(t *testing.T) {
+	keAddr, config := startNTSServers(t)
+
+	session, err := ntsKeyExchange(keAddr, config, time.Second)
+	if err != nil {
+		t.Fatalf("Key exchange failed: %v", err)
+	}
+	if len(session.cookies) != ntsCookieCount {
+		t.Errorf("Expected %d cookies, got %d", ntsCookieCount, len(session.cookies))
+	}
+
+	// Несколько запросов подряд: запас cookie должен восполняться сервером
+	for i := 0; i < 3; i++ {
+		response, err := ntp.QueryWithOptions(session.server, ntp.QueryOptions{
+			Timeout:    time.Second,
+			Extensions: []ntp.Extension{session},
+		})
+		if err != nil {
+			t.Fatalf("NTS query failed: %v", err)
+		}
+		if err := response.Validate(); err != nil {
+			t.Fatalf("Invalid response: %v", err)
+		}
+		if len(session.cookies) != ntsCookieCount {
+			t.Errorf("Expected cookie pool to be refilled to %d, got %d", ntsCookieCount, len(session.cookies))
+		}
+	}
+
+	// Полный путь через функцию опроса, которую использует CLI
+	nowInfo, err := printCurrentTime(systemClock{}, NewNTPSource([]string{keAddr}, ntsQuery(config, time.Second)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if abs(nowInfo.offset) > time.Second {
+		t.Errorf("Unexpected offset from loopback server: %v", nowInfo.offset)
+	}
+}
+
+func TestNTSRejectsForgedCookie(t *testing.T) {
+	keAddr, config := startNTSServers(t)
+
+	session, err := ntsKeyExchange(keAddr, config, time.Second)
+	if err != nil {
+		t.Fatalf("Key exchange failed: %v", err)
+	}
+	session.cookies[0][len(session.cookies[0])-1] ^= 1
+
+	_, err = ntp.QueryWithOptions(session.server, ntp.QueryOptions{
+		Timeout:    time.Second,
+		Extensions: []ntp.Extension{session},
+	})
+	if !errors.Is(err, errNTSNak) {
+		t.Errorf("Expected errNTSNak, got: %v", err)
+	}
+}
+
+func TestNTSNakRequiresUniqueID(t *testing.T) {
+	session := &ntsSession{uniqueID: bytes.Repeat([]byte{1}, uniqueIDSize)}
+
+	tests := []struct {
+		name     string
+		uniqueID []byte
+		nak      bool
+	}{
+		{"matching identifier", session.uniqueID, true},
+		{"spoofed identifier", bytes.Repeat([]byte{2}, uniqueIDSize), false},
+		{"no identifier", nil, false},
+	}
+	for _, test := range tests {
+		err := session.ProcessResponse(ntsNak(make([]byte, packetSize), test.uniqueID))
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		if errors.Is(err, errNTSNak) != test.nak {
+			t.Errorf("%s: got %v, NTSN accepted should be %v", test.name, err, test.nak)
+		}
+	}
+}
+
+// countingProxy пересылает TCP соединения на target и считает их
+func countingProxy(t *testing.T, target string) (string, func() int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	count := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+			go func() {
+				defer conn.Close()
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener.Addr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+// TestNTSQueryReusesSession проверяет, что опросы используют cookie из одного NTS-KE,
+// а новый обмен ключами выполняется после отказа NTSN
+func TestNTSQueryReusesSession(t *testing.T) {
+	keAddr, config := startNTSServers(t)
+	proxyAddr, handshakes := countingProxy(t, keAddr)
+	sessions := &ntsSessions{config: config, timeout: time.Second, clients: make(map[string]*ntsClient)}
+
+	for i := 0; i < 2*ntsCookieCount; i++ {
+		if _, err := sessions.query(proxyAddr); err != nil {
+			t.Fatalf("query %d failed: %v", i, err)
+		}
+	}
+	if n := handshakes(); n != 1 {
+		t.Errorf("Expected 1 key exchange for %d queries, got %d", 2*ntsCookieCount, n)
+	}
+
+	// Сервер больше не принимает cookie: запрос получает NTSN и повторяется с новыми ключами
+	session := sessions.clients[proxyAddr].session
+	for _, cookie := range session.cookies {
+		cookie[len(cookie)-1] ^= 1
+	}
+	if _, err := sessions.query(proxyAddr); err != nil {
+		t.Fatalf("query after NTSN failed: %v", err)
+	}
+	if n := handshakes(); n != 2 {
+		t.Errorf("Expected a new key exchange after NTSN, got %d key exchanges", n)
+	}
+	if sessions.clients[proxyAddr].session == session {
+		t.Error("Expected the rejected session to be replaced")
+	}
+}
+
+func TestNTSUntrustedCertificate(t *testing.T) {
+	keAddr, _ := startNTSServers(t)
+	if _, err := ntsKeyExchange(keAddr, &tls.Config{ServerName: "localhost"}, time.Second); err == nil {
+		t.Error("Expected key exchange to fail for untrusted certificate")
+	}
+}
+
+// recordingAdjuster запоминает вызовы вместо коррекции часов
+type recordingAdjuster struct {
+	calls []string
+	err   error
+}
+
+func (a *recordingAdjuster) slew(offset time.Duration) error {
+	a.calls = append(a.calls, "slew "+offset.String())
+	return a.err
+}
+
+func (a *recordingAdjuster) step(offset time.Duration) error {
+	a.calls = append(a.calls, "step "+offset.String())
+	return a.err
+}
+
+func TestCorrectClock(t *testing.T) {
+	policy := clockPolicy{stepThreshold: 128 * time.Millisecond, maxOffset: time.Hour}
+	tests := []struct {
+		offset  time.Duration
+		action  string
+		calls   []string
+		wantErr bool
+	}{
+		{0, "none", nil, false},
+		{5 * time.Millisecond, "slew", []string{"slew 5ms"}, false},
+		{-127 * time.Millisecond, "slew", []string{"slew -127ms"}, false},
+		{128 * time.Millisecond, "step", []string{"step 128ms"}, false},
+		{-3 * time.Second, "step", []string{"step -3s"}, false},
+		{2 * time.Hour, "", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.offset.String(), func(t *testing.T) {
+			adjuster := &recordingAdjuster{}
+			action, err := correctClock(adjuster, test.offset, policy)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error status: got %v, want %v", err, test.wantErr)
+			}
+			if action != test.action || !reflect.DeepEqual(adjuster.calls, test.calls) {
+				t.Errorf("correctClock() = %q with calls %v, want %q with %v", action, adjuster.calls, test.action, test.calls)
+			}
+		})
+	}
+
+	adjuster := &recordingAdjuster{err: errors.New("operation not permitted")}
+	if _, err := correctClock(adjuster, time.Second, policy); err == nil {
+		t.Error("Expected adjuster error to be returned")
+	}
+}
+
+func TestResolveOutput(t *testing.T) {
+	tests := []struct {
+		output    string
+		outputSet bool
+		asJSON    bool
+		diag      bool
+		expected  string
+		wantErr   bool
+	}{
+		{"text", false, false, false, "text", false},
+		{"line", true, false, false, "line", false},
+		{"text", false, true, false, "json", false},
+		{"text", false, true, true, "json", false},
+		{"json", true, true, false, "json", false},
+		{"text", true, true, false, "", true},
+		{"line", true, true, false, "", true},
+		{"xml", true, false, false, "", true},
+		{"line", true, false, true, "", true},
+		{"json", true, false, true, "json", false},
+	}
+
+	for _, test := range tests {
+		output, err := resolveOutput(test.output, test.outputSet, test.asJSON, test.diag)
+		if (err != nil) != test.wantErr || output != test.expected {
+			t.Errorf("resolveOutput(%q, %v, %v, %v) = %q, %v; want %q, error %v",
+				test.output, test.outputSet, test.asJSON, test.diag, output, err, test.expected, test.wantErr)
+		}
+	}
+}
+
+func TestCheckClockSource(t *testing.T) {
+	if err := checkClockSource("ntp"); err != nil {
+		t.Errorf("Unexpected error for ntp source: %v", err)
+	}
+	for _, source := range []string{"fixed", "system", "http"} {
+		if err := checkClockSource(source); err == nil {
+			t.Errorf("Expected -set to be rejected for %s source", source)
+		}
+	}
+}
+
+func TestDryRunAdjuster(t *testing.T) {
+	var out bytes.Buffer
+	adjuster := dryRunAdjuster{w: &out, clock: fakeClock{time.Unix(1700000000, 250000000)}}
+	policy := clockPolicy{stepThreshold: defaultStepThreshold}
+
+	if _, err := correctClock(adjuster, -1500*time.Microsecond, policy); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := correctClock(adjuster, 2*time.Second+500*time.Millisecond, policy); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := "adjtimex(modes=ADJ_OFFSET_SINGLESHOT, offset=-1500us)\n" +
+		"settimeofday(tv_sec=1700000002, tv_usec=750000)\n"
+	if out.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestTimeFormat(t *testing.T) {
+	moment := time.Date(2024, 3, 1, 9, 5, 7, 123456789, time.UTC)
+	tests := []struct {
+		spec string
+		tz   string
+		want string
+	}{
+		{"rfc3339", "UTC", "2024-03-01T09:05:07Z"},
+		{"RFC3339Nano", "UTC", "2024-03-01T09:05:07.123456789Z"},
+		{"rfc3339", "Europe/Moscow", "2024-03-01T12:05:07+03:00"},
+		{"unix", "Asia/Tokyo", "1709283907"},
+		{"unixms", "UTC", "1709283907123"},
+		{"unixns", "UTC", "1709283907123456789"},
+		{"2006/01/02 15:04", "America/New_York", "2024/03/01 04:05"},
+		{"Jan _2", "UTC", "Mar  1"},
+		{"15h", "UTC", "09h"},
+		{"%Y-%m-%d %H:%M:%S %Z", "Europe/Moscow", "2024-03-01 12:05:07 MSK"},
+		{"%a %b %e %l%p, day %j, %s, 100%%", "UTC", "Fri Mar  1  9AM, day 061, 1709283907, 100%"},
+		{"%F %T %z", "Asia/Kolkata", "2024-03-01 14:35:07 +0530"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			f, err := parseTimeFormat(test.spec, test.tz)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := f.format(moment); got != test.want {
+				t.Errorf("format() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeFormatErrors(t *testing.T) {
+	for _, test := range []struct{ spec, tz string }{
+		{"rfc3339", "Mars/Olympus_Mons"},
+		{"%Y-%q", "UTC"},
+		{"%Y%", "UTC"},
+		{"", "UTC"},
+		{"rfc339", "UTC"},
+		{"unixs", "UTC"},
+		{"rfc822z", "UTC"},
+	} {
+		if _, err := parseTimeFormat(test.spec, test.tz); err == nil {
+			t.Errorf("Expected error for format %q in %q", test.spec, test.tz)
+		}
+	}
+}
+
+func TestWriteTimeReport(t *testing.T) {
+	local := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	source := &NTPSource{
+		servers: []string{"a", "b"},
+		query: fakeQuery(map[string]*ntp.Response{
+			"a": fakeResponse(1500*time.Millisecond, 10*time.Millisecond),
+		}),
+		clock: fakeClock{local},
+	}
+	nowInfo, err := printCurrentTime(fakeClock{local}, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	f, err := parseTimeFormat("rfc3339nano", "Europe/Moscow")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := writeTimeReport(&out, nowInfo, f, "text"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "Local Time: 2024-03-01T15:00:00+03:00\nNTP Time: 2024-03-01T15:00:01.5+03:00\nOffset: 1.5s\n" +
+		"Agreed servers: a\nUnreachable servers: b\n"
+	if out.String() != want {
+		t.Errorf("Unexpected text output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := writeTimeReport(&out, nowInfo, f, "json"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var report timeReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %v", err)
+	}
+	wantReport := timeReport{
+		LocalTime:    "2024-03-01T15:00:00+03:00",
+		Source:       "NTP",
+		SourceTime:   "2024-03-01T15:00:01.5+03:00",
+		OffsetNs:     int64(1500 * time.Millisecond),
+		Agreed:       []string{"a"},
+		Unreachable:  []string{"b"},
+		LocalUnixNs:  local.UnixNano(),
+		SourceUnixNs: local.Add(1500 * time.Millisecond).UnixNano(),
+	}
+	if !reflect.DeepEqual(report, wantReport) {
+		t.Errorf("Unexpected JSON report: %+v", report)
+	}
+
+	out.Reset()
+	if err := writeTimeReport(&out, nowInfo, f, "line"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantLine := `clock,source=NTP offset_ns=1500000000i,agreed=1i,rejected=0i,unreachable=1i,source_time="2024-03-01T15:00:01.5+03:00" 1709294400000000000` + "\n"
+	if out.String() != wantLine {
+		t.Errorf("Unexpected line output:\n%s\nwant:\n%s", out.String(), wantLine)
+	}
+
+	if err := writeTimeReport(&out, nowInfo, f, "xml"); err == nil {
+		t.Error("Expected error for unknown output format")
+	}
+}
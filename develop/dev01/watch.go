@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Код выхода, с которым завершается режим наблюдения при превышении порога
+const alertExitCode = 2
+
+// watchConfig параметры режима наблюдения за дрейфом часов
+type watchConfig struct {
+	// history - сколько последних измерений хранить для оценки дрейфа
+	history int
+	// threshold - допустимое по модулю смещение, 0 - без проверки
+	threshold time.Duration
+	// exitOnAlert - завершать работу при первом превышении порога
+	exitOnAlert bool
+	// count - сколько раз опросить серверы, 0 - без ограничения
+	count int
+}
+
+// offsetPoint одно измерение смещения часов
+type offsetPoint struct {
+	at     time.Time
+	offset time.Duration
+}
+
+// driftMonitor хранит скользящую историю смещений
+type driftMonitor struct {
+	size    int
+	history []offsetPoint
+}
+
+func newDriftMonitor(size int) *driftMonitor {
+	// Для оценки наклона нужно минимум два измерения
+	if size < 2 {
+		size = 2
+	}
+	return &driftMonitor{size: size, history: make([]offsetPoint, 0, size)}
+}
+
+// add добавляет измерение, вытесняя самое старое при переполнении
+func (m *driftMonitor) add(point offsetPoint) {
+	if len(m.history) == m.size {
+		copy(m.history, m.history[1:])
+		m.history = m.history[:len(m.history)-1]
+	}
+	m.history = append(m.history, point)
+}
+
+// driftPPM оценивает скорость дрейфа в миллионных долях методом наименьших квадратов.
+// Второе значение false, если измерений недостаточно для оценки
+func (m *driftMonitor) driftPPM() (float64, bool) {
+	n := float64(len(m.history))
+	if n < 2 {
+		return 0, false
+	}
+
+	start := m.history[0].at
+	var sumX, sumY, sumXY, sumXX float64
+	for _, p := range m.history {
+		x := p.at.Sub(start).Seconds()
+		y := p.offset.Seconds()
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	// Если все измерения сделаны в один момент - наклон не определен
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+	slope := (n*sumXY - sumX*sumY) / denominator
+
+	return slope * 1e6, true
+}
+
+// runWatch опрашивает серверы на каждом тике, печатает смещение и дрейф
+// и сообщает о превышении порога. Работа заканчивается, когда канал тиков закрыт
+// или выполнено заданное число опросов
+func runWatch(w io.Writer, servers []string, query queryFunc, cfg watchConfig, ticks <-chan time.Time) int {
+	monitor := newDriftMonitor(cfg.history)
+
+	polls := 0
+	for at := range ticks {
+		polls++
+		selected, err := marzullo(queryServers(servers, query))
+		if err != nil {
+			fmt.Fprintf(w, "%s error: %v\n", at.UTC().Format(time.RFC3339), err)
+		} else {
+			monitor.add(offsetPoint{at: at, offset: selected.offset})
+
+			drift := "n/a"
+			if ppm, ok := monitor.driftPPM(); ok {
+				drift = fmt.Sprintf("%+.3fppm", ppm)
+			}
+			fmt.Fprintf(w, "%s offset=%v drift=%s agreed=%d/%d\n",
+				at.UTC().Format(time.RFC3339), selected.offset, drift, len(selected.truechimers), len(servers))
+
+			if cfg.threshold > 0 && abs(selected.offset) > cfg.threshold {
+				fmt.Fprintf(w, "%s ALERT: offset %v exceeds threshold %v\n",
+					at.UTC().Format(time.RFC3339), selected.offset, cfg.threshold)
+				if cfg.exitOnAlert {
+					return alertExitCode
+				}
+			}
+		}
+
+		if cfg.count > 0 && polls >= cfg.count {
+			break
+		}
+	}
+
+	return 0
+}
+
+// watchTicks возвращает канал, который выдает тик сразу и затем с заданным интервалом,
+// и закрывается после сигнала в stop
+func watchTicks(interval time.Duration, stop <-chan struct{}) <-chan time.Time {
+	ticks := make(chan time.Time)
+	go func() {
+		defer close(ticks)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		next := time.Now()
+		for {
+			select {
+			case ticks <- next:
+			case <-stop:
+				return
+			}
+			select {
+			case next = <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return ticks
+}
+
+// abs возвращает модуль длительности
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
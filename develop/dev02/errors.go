@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind вид ошибки разбора упакованной строки
+type ErrorKind int
+
+const (
+	// LeadingDigit строка начинается с цифры
+	LeadingDigit ErrorKind = iota + 1
+	// RepeatedDigit цифра стоит сразу после числа повторений
+	RepeatedDigit
+	// BadEscape экранирована руна, не являющаяся цифрой или обратной косой чертой
+	BadEscape
+	// UnterminatedEscape строка заканчивается обратной косой чертой
+	UnterminatedEscape
+	// ZeroCount число повторений равно нулю или начинается с нуля (Version2)
+	ZeroCount
+	// CountTooLarge число повторений больше MaxCount (Version2)
+	CountTooLarge
+)
+
+// String возвращает описание вида ошибки
+func (k ErrorKind) String() string {
+	switch k {
+	case LeadingDigit:
+		return "digit at the start of the string"
+	case RepeatedDigit:
+		return "digit after repeat count"
+	case BadEscape:
+		return "only digits and backslash can be escaped"
+	case UnterminatedEscape:
+		return "unterminated escape sequence"
+	case ZeroCount:
+		return "repeat count starts with zero"
+	case CountTooLarge:
+		return "repeat count is too large"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// UnpackError ошибка разбора упакованной строки
+type UnpackError struct {
+	Kind ErrorKind
+	// Offset и ByteOffset - смещение руны, на которой обнаружена ошибка, в рунах и байтах.
+	// Для UnterminatedEscape это длина входных данных
+	Offset     int
+	ByteOffset int64
+	// Rune руна, на которой обнаружена ошибка, 0 для UnterminatedEscape
+	Rune rune
+}
+
+// Error возвращает текст ошибки
+func (e *UnpackError) Error() string {
+	if e.Kind == UnterminatedEscape {
+		return fmt.Sprintf("uncorrect string at rune %d (byte %d): %v", e.Offset, e.ByteOffset, e.Kind)
+	}
+	return fmt.Sprintf("uncorrect string at rune %d (byte %d): %v %q", e.Offset, e.ByteOffset, e.Kind, e.Rune)
+}
+
+// Diagnostic возвращает сообщение об ошибке разбора input с указателем на место ошибки:
+//
+//	a4b12
+//	    ^ digit after repeat count
+//
+// Для ошибок другого типа возвращается текст ошибки
+func Diagnostic(input string, err error) string {
+	var unpackErr *UnpackError
+	if !errors.As(err, &unpackErr) {
+		return err.Error()
+	}
+
+	// Указатель сдвигается пробелами, табуляции сохраняются, чтобы не сбить выравнивание
+	var b strings.Builder
+	b.WriteString(input)
+	b.WriteByte('\n')
+	i := 0
+	for _, char := range input {
+		if i == unpackErr.Offset {
+			break
+		}
+		if char == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		i++
+	}
+	b.WriteString("^ ")
+	b.WriteString(unpackErr.Kind.String())
+	return b.String()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/uniseg"
+)
+
+// Pack выполняет упаковку строки в формате Version1
+func Pack(str string) string {
+	return Format{Version: Version1}.Pack(str)
+}
+
+// Pack выполняет упаковку строки в формате f. Результат - самая короткая (в байтах)
+// строка, которую Unpack того же формата распаковывает обратно в str.
+// В режиме графем серия перед графемой, которая слилась бы с цифрой числа
+// (например, одиночный combining mark после перевода строки), заканчивается символом без числа
+func (f Format) Pack(str string) string {
+	var result strings.Builder
+	units := f.split(str)
+	for i := 0; i < len(units); {
+		// Ищем серию одинаковых символов
+		j := i + 1
+		for j < len(units) && units[j] == units[i] {
+			j++
+		}
+
+		symbol := units[i]
+		if symbol == `\` || len(symbol) == 1 && isDigit(rune(symbol[0])) {
+			symbol = `\` + symbol
+		}
+		var counts []int
+		if f.Graphemes && j < len(units) && j-i > 1 && !startsCluster(units[j]) {
+			counts = append(splitRun(j-i-1, len(symbol), f.maxCount()), 1)
+		} else {
+			counts = splitRun(j-i, len(symbol), f.maxCount())
+		}
+		for _, count := range counts {
+			result.WriteString(symbol)
+			if count > 1 {
+				result.WriteString(strconv.Itoa(count))
+			}
+		}
+		i = j
+	}
+	return result.String()
+}
+
+// split делит строку на символы формата: руны или графемы
+func (f Format) split(str string) []string {
+	var units []string
+	if !f.Graphemes {
+		for _, char := range []rune(str) {
+			units = append(units, string(char))
+		}
+		return units
+	}
+
+	state := -1
+	for len(str) > 0 {
+		var cluster string
+		cluster, str, _, state = uniseg.FirstGraphemeClusterInString(str, state)
+		units = append(units, cluster)
+	}
+	return units
+}
+
+// startsCluster проверяет, что графема cluster не сольется с цифрой перед ней
+func startsCluster(cluster string) bool {
+	first, _, _, _ := uniseg.FirstGraphemeClusterInString("0"+cluster, -1)
+	return first == "0"
+}
+
+// countClass множество длин кусков серии с числом повторений из одинакового количества цифр
+type countClass struct {
+	lo, hi int
+	digits int
+}
+
+// countClasses возвращает классы длин кусков при ограничении maxCount:
+// 1 (без числа), 2-9, 10-99 и т.д.
+func countClasses(maxCount int) []countClass {
+	classes := []countClass{{lo: 1, hi: 1}}
+	lo, hi := 2, 9
+	for digits := 1; lo <= maxCount; digits++ {
+		if hi > maxCount {
+			hi = maxCount
+		}
+		classes = append(classes, countClass{lo: lo, hi: hi, digits: digits})
+		lo, hi = hi+1, hi*10+9
+	}
+	return classes
+}
+
+// splitRun делит серию из n символов, каждый из которых записывается symbolLen байтами,
+// на куски с наименьшей суммарной длиной записи.
+// Стоимость куска зависит только от количества цифр в числе, поэтому ищется
+// наименьшая стоимость c, при которой набор классов может покрыть n символов:
+// cover[c] - наибольшее число символов набора стоимостью ровно c.
+// Нижние границы классов при этом не мешают: если сумма lo набора больше n,
+// то замена любого куска на кусок соседнего меньшего класса (hi которого равен lo - 1)
+// дает более дешевый набор, который все еще покрывает n, что противоречит минимальности c
+func splitRun(n, symbolLen, maxCount int) []int {
+	classes := countClasses(maxCount)
+	cover := []int{0}
+	choice := []int{-1}
+	for cover[len(cover)-1] < n {
+		c := len(cover)
+		best, bestClass := -1, -1
+		for k, class := range classes {
+			cost := symbolLen + class.digits
+			if cost > c || cover[c-cost] < 0 {
+				continue
+			}
+			if covered := cover[c-cost] + class.hi; covered > best {
+				best, bestClass = covered, k
+			}
+		}
+		cover = append(cover, best)
+		choice = append(choice, bestClass)
+	}
+
+	// Восстанавливаем набор классов и раздаем символы сверх нижних границ
+	var chunks []countClass
+	rest := n
+	for c := len(cover) - 1; c > 0; {
+		class := classes[choice[c]]
+		chunks = append(chunks, class)
+		rest -= class.lo
+		c -= symbolLen + class.digits
+	}
+	// Большие куски идут первыми, так запись привычнее: "a99a6", а не "a6a99"
+	sort.Slice(chunks, func(a, b int) bool { return chunks[a].digits > chunks[b].digits })
+	counts := make([]int, len(chunks))
+	for k, class := range chunks {
+		extra := class.hi - class.lo
+		if extra > rest {
+			extra = rest
+		}
+		counts[k] = class.lo + extra
+		rest -= extra
+	}
+	return counts
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// UnpackWriter распаковывает записываемые в него данные и пишет результат в другой io.Writer.
+// Память не зависит от размера данных: хранятся только буфер вывода и начало руны,
+// разрезанной между вызовами Write. В режиме графем хранится еще необработанный остаток
+// последней порции, так как графема может продолжиться в следующем вызове Write
+type UnpackWriter struct {
+	out       *bufio.Writer
+	dec       *decoder
+	carry     []byte
+	graphemes bool
+	// state состояние сегментации uniseg после последней обработанной графемы
+	state int
+	err   error
+}
+
+// errClosed возвращается при записи в закрытый UnpackWriter
+var errClosed = errors.New("unpack: write to closed writer")
+
+// NewUnpackWriter создает UnpackWriter формата Version1, пишущий в w
+func NewUnpackWriter(w io.Writer) *UnpackWriter {
+	return Format{Version: Version1}.NewUnpackWriter(w)
+}
+
+// NewUnpackWriter создает UnpackWriter формата f, пишущий в w
+func (f Format) NewUnpackWriter(w io.Writer) *UnpackWriter {
+	out := bufio.NewWriter(w)
+	return &UnpackWriter{
+		out:       out,
+		dec:       newDecoder(f, out),
+		carry:     make([]byte, 0, 2*utf8.UTFMax),
+		graphemes: f.Graphemes,
+		state:     -1,
+		err:       f.validate(),
+	}
+}
+
+// Write распаковывает очередную порцию данных. Часть результата может оставаться
+// в буфере до вызова Close
+func (u *UnpackWriter) Write(p []byte) (int, error) {
+	if u.err != nil {
+		return 0, u.err
+	}
+	if u.graphemes {
+		return u.writeClusters(p)
+	}
+
+	// consumed - сколько байт p уже разобрано. Байты руны из прошлого вызова в это число
+	// не входят: по контракту io.Writer результат должен быть от 0 до len(p)
+	consumed := 0
+	if len(u.carry) > 0 {
+		// Дописываем к началу руны из прошлого вызова несколько байт из p
+		head := append(u.carry, p[:minInt(len(p), utf8.UTFMax)]...)
+		i := 0
+		for i < len(u.carry) && utf8.FullRune(head[i:]) {
+			char, size := utf8.DecodeRune(head[i:])
+			if err := u.decode(char, size); err != nil {
+				// Разобранные до ошибки руны целиком лежат в байтах прошлого вызова
+				return 0, err
+			}
+			i += size
+		}
+		if i < len(u.carry) {
+			// p слишком короткий, чтобы закончить руну
+			u.carry = append(u.carry[:0], head[i:]...)
+			return len(p), nil
+		}
+		consumed = i - len(u.carry)
+		u.carry = u.carry[:0]
+	}
+
+	for utf8.FullRune(p[consumed:]) {
+		char, size := utf8.DecodeRune(p[consumed:])
+		if err := u.decode(char, size); err != nil {
+			return consumed, err
+		}
+		consumed += size
+	}
+	u.carry = append(u.carry, p[consumed:]...)
+	return len(p), nil
+}
+
+// writeClusters распаковывает порцию данных по графемам. Последняя графема порции
+// откладывается до следующего вызова: к ней еще могут добавиться руны
+func (u *UnpackWriter) writeClusters(p []byte) (int, error) {
+	u.carry = append(u.carry, p...)
+	// Неполная руна в конце порции еще не может участвовать в сегментации
+	complete := len(u.carry)
+	for i := len(u.carry) - 1; i >= 0 && i >= len(u.carry)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(u.carry[i]) {
+			if !utf8.FullRune(u.carry[i:]) {
+				complete = i
+			}
+			break
+		}
+	}
+
+	rest := u.carry[:complete]
+	for {
+		cluster, next, _, state := uniseg.FirstGraphemeCluster(rest, u.state)
+		if len(next) == 0 {
+			break
+		}
+		if err := u.decodeCluster(cluster); err != nil {
+			return 0, err
+		}
+		rest, u.state = next, state
+	}
+	u.carry = append(u.carry[:0], u.carry[complete-len(rest):]...)
+	return len(p), nil
+}
+
+// Close распаковывает остаток данных, проверяет, что строка не оборвана
+// на середине escape-последовательности, и сбрасывает буфер вывода.
+// Нижележащий io.Writer не закрывается
+func (u *UnpackWriter) Close() error {
+	if u.err != nil {
+		return u.err
+	}
+	for u.graphemes && len(u.carry) > 0 {
+		var cluster []byte
+		cluster, u.carry, _, u.state = uniseg.FirstGraphemeCluster(u.carry, u.state)
+		if err := u.decodeCluster(cluster); err != nil {
+			return err
+		}
+	}
+	// Оставшиеся байты - неполная руна, как и в строке, каждый из них дает utf8.RuneError
+	for len(u.carry) > 0 {
+		char, size := utf8.DecodeRune(u.carry)
+		if err := u.decode(char, size); err != nil {
+			return err
+		}
+		u.carry = u.carry[size:]
+	}
+	if err := u.dec.finish(); err != nil {
+		u.err = err
+		return err
+	}
+	if err := u.out.Flush(); err != nil {
+		u.err = err
+		return err
+	}
+	u.err = errClosed
+	return nil
+}
+
+// decode передает руну декодеру, запоминая ошибку
+func (u *UnpackWriter) decode(char rune, size int) error {
+	if err := u.dec.next(char, size); err != nil {
+		u.err = err
+		return err
+	}
+	return nil
+}
+
+// decodeCluster передает графему декодеру, запоминая ошибку
+func (u *UnpackWriter) decodeCluster(cluster []byte) error {
+	if err := u.dec.nextCluster(string(cluster)); err != nil {
+		u.err = err
+		return err
+	}
+	return nil
+}
+
+// UnpackReader распаковывает данные формата Version1 из r в w
+func UnpackReader(w io.Writer, r io.Reader) error {
+	return Format{Version: Version1}.UnpackReader(w, r)
+}
+
+// UnpackReader распаковывает данные формата f из r в w. Ошибка в некорректных данных
+// имеет тип *UnpackError со смещением от начала потока
+func (f Format) UnpackReader(w io.Writer, r io.Reader) error {
+	u := f.NewUnpackWriter(w)
+	if _, err := io.Copy(u, r); err != nil {
+		return err
+	}
+	return u.Close()
+}
+
+// minInt возвращает меньшее из двух чисел
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"strconv"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
 )
 
 /*
@@ -23,53 +29,281 @@ import (
 Функция должна проходить все тесты. Код должен проходить проверки go vet и golint.
 */
 
-// Unpack выполняет распаковку строки и возвращает результат и ошибку.
+// Version версия формата упакованной строки
+type Version int
+
+const (
+	// Version1 исходный формат: число повторений - одна цифра
+	Version1 Version = iota + 1
+	// Version2 число повторений может состоять из нескольких цифр, но не больше MaxCount
+	Version2
+)
+
+// DefaultMaxCount ограничение числа повторений в Version2 по умолчанию.
+// Без ограничения короткая строка вида "a999999999" распаковывается в гигабайт
+const DefaultMaxCount = 4096
+
+// Format параметры формата упакованной строки
+type Format struct {
+	Version Version
+	// MaxCount наибольшее число повторений для Version2, 0 - DefaultMaxCount
+	MaxCount int
+	// Graphemes - единицей строки считается графема (UAX #29), а не руна:
+	// число повторяет весь видимый символ, например букву с диакритикой или флаг
+	Graphemes bool
+}
+
+// maxCount возвращает наибольшее допустимое число повторений
+func (f Format) maxCount() int {
+	if f.Version != Version2 {
+		return 9
+	}
+	if f.MaxCount <= 0 {
+		return DefaultMaxCount
+	}
+	return f.MaxCount
+}
+
+// Unpack выполняет распаковку строки в формате Version1 и возвращает результат и ошибку.
+// Обратная косая черта экранирует следующую за ней цифру или обратную косую черту:
+// такой символ выводится как есть и может быть повторен следующей цифрой.
 func Unpack(str string) (string, error) {
+	return Format{Version: Version1}.Unpack(str)
+}
+
+// Unpack выполняет распаковку строки в формате f. В Version2 число повторений
+// может быть многозначным, но без ведущих нулей и в пределах от 1 до MaxCount.
+// Ошибка в некорректной строке имеет тип *UnpackError
+func (f Format) Unpack(str string) (string, error) {
 	// Рассматриваем краевой случай, при котором на вход поступила пустая строка
 	if len(str) == 0 {
 		return "", nil
 	}
-	// Преобразуем строку в слайс рун
-	runes := []rune(str)
-	// Предотвращаем дальнейшие аллокации, рассматривая краевой случай,
-	// где после каждого символа стоит 9, поэтому ставим capacity в длину строки * 10
-	result := make([]rune, 0, len(runes)*10)
-
-	for index, char := range runes {
-		// Проверяем руну на число
-		if isDigit(char) {
-			// Рассматривае случаи некорректных строк, по заданию
-			if index == 0 {
-				return "", fmt.Errorf("uncorrect string: %s", str)
-			} else if isDigit(runes[index-1]) {
-				return "", fmt.Errorf("uncorrect string: %s", str)
-			} else if char == '1' {
-				// Если число 1 - нам ничего делать не нужно
-				continue
-			} else {
-				// Преобразуем руну в число
-				number, err := strconv.Atoi(string(char))
-				if err != nil {
-					return "", err
-				}
-				// В цикле, добавляем предыдущую, перед числом, руну на нужное количество раз
-				// number - 1 потому, что руну-символ мы уже добавили
-				for i := 0; i < number-1; i++ {
-					result = append(result, runes[index-1])
-				}
-				// Переходим к следующей итерации, чтобы не добавлять число в результирующую строку
-				continue
-			}
+	if err := f.validate(); err != nil {
+		return "", err
+	}
+
+	var result strings.Builder
+	result.Grow(len(str))
+	d := newDecoder(f, &result)
+	state := -1
+	for rest := str; len(rest) > 0; {
+		var err error
+		if f.Graphemes {
+			var cluster string
+			cluster, rest, _, state = uniseg.FirstGraphemeClusterInString(rest, state)
+			err = d.nextCluster(cluster)
+		} else {
+			char, size := utf8.DecodeRuneInString(rest)
+			err = d.next(char, size)
+			rest = rest[size:]
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := d.finish(); err != nil {
+		return "", err
+	}
+	return result.String(), nil
+}
+
+// validate проверяет, что версия формата известна
+func (f Format) validate() error {
+	if f.Version != Version1 && f.Version != Version2 {
+		return fmt.Errorf("unknown format version %d", f.Version)
+	}
+	return nil
+}
+
+// textWriter приемник распакованного текста: strings.Builder или bufio.Writer
+type textWriter interface {
+	WriteRune(r rune) (int, error)
+	WriteString(s string) (int, error)
+}
+
+// decoder распаковывает строку по одной руне, поэтому годится и для потоков
+type decoder struct {
+	version  Version
+	maxCount int
+	out      textWriter
+	// err первая ошибка записи в out
+	err error
+	// runes и bytes - смещение очередной руны от начала данных
+	runes int
+	bytes int64
+	// prev - последний добавленный символ, который может повторить следующая цифра,
+	// canRepeat - можно ли сейчас поставить цифру, escaped - предыдущая руна была '\',
+	// count - накопленное число повторений, counting - сейчас читается число (Version2),
+	// prevCluster - последний символ, если это графема из нескольких рун
+	prev        rune
+	prevCluster string
+	canRepeat   bool
+	escaped     bool
+	count       int
+	counting    bool
+}
+
+// newDecoder создает декодер формата f, пишущий в out
+func newDecoder(f Format, out textWriter) *decoder {
+	return &decoder{version: f.Version, maxCount: f.maxCount(), out: out}
+}
+
+// emit записывает руну в out, запоминая первую ошибку записи
+func (d *decoder) emit(char rune) {
+	if d.err != nil {
+		return
+	}
+	if _, err := d.out.WriteRune(char); err != nil {
+		d.err = err
+	}
+}
+
+// emitString записывает графему в out, запоминая первую ошибку записи
+func (d *decoder) emitString(cluster string) {
+	if d.err != nil {
+		return
+	}
+	if _, err := d.out.WriteString(cluster); err != nil {
+		d.err = err
+	}
+}
+
+// emitPrev повторяет последний символ
+func (d *decoder) emitPrev() {
+	if d.prevCluster == "" {
+		d.emit(d.prev)
+		return
+	}
+	d.emitString(d.prevCluster)
+}
+
+// fail создает ошибку разбора на текущей руне
+func (d *decoder) fail(kind ErrorKind, char rune) error {
+	return &UnpackError{Kind: kind, Offset: d.runes, ByteOffset: d.bytes, Rune: char}
+}
+
+// next обрабатывает очередную руну, занимающую size байт во входных данных
+func (d *decoder) next(char rune, size int) error {
+	if err := d.decode(char); err != nil {
+		return err
+	}
+	d.runes++
+	d.bytes += int64(size)
+	return d.err
+}
+
+// nextCluster обрабатывает очередную графему. Графема из одной руны разбирается
+// как руна, а из нескольких - всегда символ, даже если начинается с цифры или '\'
+func (d *decoder) nextCluster(cluster string) error {
+	char, size := utf8.DecodeRuneInString(cluster)
+	if size == len(cluster) {
+		return d.next(char, size)
+	}
+
+	if d.counting {
+		d.repeat()
+	}
+	if d.escaped {
+		return d.fail(BadEscape, char)
+	}
+	d.emitString(cluster)
+	d.prev, d.prevCluster, d.canRepeat = char, cluster, true
+	d.runes += utf8.RuneCountInString(cluster)
+	d.bytes += int64(len(cluster))
+	return d.err
+}
+
+// decode выполняет разбор руны
+func (d *decoder) decode(char rune) error {
+	// Число заканчивается на первой руне, не являющейся цифрой
+	if d.counting && !isDigit(char) {
+		d.repeat()
+	}
 
+	switch {
+	case d.escaped:
+		// Экранировать можно только цифры и саму обратную косую черту
+		if !isDigit(char) && char != '\\' {
+			return d.fail(BadEscape, char)
 		}
+		d.emit(char)
+		d.prev, d.prevCluster, d.canRepeat, d.escaped = char, "", true, false
+	case char == '\\':
+		d.escaped = true
+	case isDigit(char) && d.counting:
+		// Число больше maxCount не допускается
+		d.count = d.count*10 + int(char-'0')
+		if d.count > d.maxCount {
+			return d.fail(CountTooLarge, char)
+		}
+	case isDigit(char):
+		// Цифра в начале строки или сразу после другой цифры - некорректная строка
+		if d.runes == 0 {
+			return d.fail(LeadingDigit, char)
+		}
+		if !d.canRepeat {
+			return d.fail(RepeatedDigit, char)
+		}
+		d.canRepeat = false
+		if d.version == Version2 {
+			// Число не может начинаться с нуля
+			if char == '0' {
+				return d.fail(ZeroCount, char)
+			}
+			d.count, d.counting = int(char-'0'), true
+			if d.count > d.maxCount {
+				return d.fail(CountTooLarge, char)
+			}
+			return nil
+		}
+		// В Version1 ноль оставляет символ в единственном экземпляре
+		for i := 0; i < int(char-'0')-1 && d.err == nil; i++ {
+			d.emitPrev()
+		}
+	default:
 		// Если не число - добавляем в результат
-		result = append(result, char)
+		d.emit(char)
+		d.prev, d.prevCluster, d.canRepeat = char, "", true
+	}
+	return nil
+}
+
+// repeat добавляет prev еще count - 1 раз, символ мы уже добавили
+func (d *decoder) repeat() {
+	for i := 0; i < d.count-1 && d.err == nil; i++ {
+		d.emitPrev()
+	}
+	d.count, d.counting = 0, false
+}
+
+// finish завершает распаковку в конце входных данных
+func (d *decoder) finish() error {
+	// Строка не может заканчиваться незавершенной escape-последовательностью
+	if d.escaped {
+		return d.fail(UnterminatedEscape, 0)
+	}
+	if d.counting {
+		d.repeat()
 	}
-	// Преобразовываем слайс рун в строку и возвращаем, с ошибкой = nil
-	return string(result), nil
+	return d.err
 }
 
 // isDigit функция, для проверки символа на цифру
 func isDigit(char rune) bool {
 	return char >= '0' && char <= '9'
 }
+
+func main() {
+	versionFlag := flag.Int("format", int(Version1), "Format version: 1 - single digit counts, 2 - multi-digit counts")
+	maxCountFlag := flag.Int("max", DefaultMaxCount, "Maximum repeat count for format 2")
+	graphemesFlag := flag.Bool("graphemes", false, "Repeat whole grapheme clusters instead of runes")
+	flag.Parse()
+
+	// Работаем как фильтр: распаковываем stdin в stdout, не читая его целиком
+	format := Format{Version: Version(*versionFlag), MaxCount: *maxCountFlag, Graphemes: *graphemesFlag}
+	if err := format.UnpackReader(os.Stdout, os.Stdin); err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
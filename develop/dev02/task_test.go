@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 )
 
 func TestUnpack(t *testing.T) {
@@ -14,6 +20,17 @@ func TestUnpack(t *testing.T) {
 		{"abcd", "abcd", false},
 		{"45", "", true},
 		{"", "", false},
+		{"a1b", "ab", false},
+		{"a12", "", true},
+		{`qwe\4\5`, "qwe45", false},
+		{`qwe\45`, "qwe44444", false},
+		{`qwe\\5`, `qwe\\\\\`, false},
+		{`\\`, `\`, false},
+		{`\12`, "11", false},
+		{`qwe\`, "", true},
+		{`qwe\\\`, "", true},
+		{`qw\e`, "", true},
+		{`\45\`, "", true},
 	}
 
 	for _, test := range tests {
@@ -31,3 +48,381 @@ func TestUnpack(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatUnpack(t *testing.T) {
+	tests := []struct {
+		format   Format
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{Format{Version: Version2}, "a12b", "aaaaaaaaaaaab", false},
+		{Format{Version: Version2}, "a1b2", "abb", false},
+		{Format{Version: Version2}, `\112\\3`, `111111111111\\\`, false},
+		{Format{Version: Version2}, "a4096", strings.Repeat("a", 4096), false},
+		{Format{Version: Version2}, "a4097", "", true},
+		{Format{Version: Version2}, "a0", "", true},
+		{Format{Version: Version2}, "a05", "", true},
+		{Format{Version: Version2}, "12", "", true},
+		{Format{Version: Version2, MaxCount: 20}, "a20", strings.Repeat("a", 20), false},
+		{Format{Version: Version2, MaxCount: 20}, "a21", "", true},
+		{Format{Version: Version2, MaxCount: 5}, "a7", "", true},
+		{Format{Version: Version2}, "a99999999999999999999", "", true},
+		{Format{Version: Version1}, "a12", "", true},
+		{Format{}, "a", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := test.format.Unpack(test.input)
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("Unexpected error status: got %v, want %v", err, test.wantErr)
+				return
+			}
+
+			if result != test.expected {
+				t.Errorf("Unpack(%s) = %s, want %s", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestPack(t *testing.T) {
+	tests := []struct {
+		format   Format
+		input    string
+		expected string
+	}{
+		{Format{Version: Version1}, "aaaabccddddde", "a4bc2d5e"},
+		{Format{Version: Version1}, "", ""},
+		{Format{Version: Version1}, "qwe45", `qwe\4\5`},
+		{Format{Version: Version1}, `qwe\\\\\`, `qwe\\5`},
+		{Format{Version: Version1}, strings.Repeat("a", 20), "a9a9a2"},
+		{Format{Version: Version1}, "ааааяя", "а4я2"},
+		{Format{Version: Version2}, strings.Repeat("a", 20), "a20"},
+		{Format{Version: Version2}, strings.Repeat("1", 12), `\112`},
+		{Format{Version: Version2, MaxCount: 100}, strings.Repeat("a", 105), "a99a6"},
+		{Format{Version: Version2, MaxCount: 11}, strings.Repeat("a", 29), "a11a9a9"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if result := test.format.Pack(test.input); result != test.expected {
+				t.Errorf("Pack(%s) = %s, want %s", test.input, result, test.expected)
+			}
+		})
+	}
+}
+
+// TestPackShortest сравнивает длину упакованной серии с перебором всех разбиений
+func TestPackShortest(t *testing.T) {
+	for _, maxCount := range []int{1, 2, 9, 10, 11, 15, 99, 100, 120} {
+		for _, symbol := range []string{"a", "1", "я"} {
+			format := Format{Version: Version2, MaxCount: maxCount}
+			symbolLen := len(format.Pack(symbol))
+			// best[n] - длина самой короткой записи серии из n символов
+			best := []int{0}
+			for n := 1; n <= 300; n++ {
+				best = append(best, -1)
+				for k := 1; k <= n && k <= maxCount; k++ {
+					length := best[n-k] + symbolLen
+					if k > 1 {
+						length += len(strconv.Itoa(k))
+					}
+					if best[n] < 0 || length < best[n] {
+						best[n] = length
+					}
+				}
+
+				run := strings.Repeat(symbol, n)
+				packed := format.Pack(run)
+				if len(packed) != best[n] {
+					t.Fatalf("MaxCount %d: Pack of %d %q has length %d, want %d", maxCount, n, symbol, len(packed), best[n])
+				}
+				if unpacked, err := format.Unpack(packed); err != nil || unpacked != run {
+					t.Fatalf("MaxCount %d: Unpack(%s) = %q, %v", maxCount, packed, unpacked, err)
+				}
+			}
+		}
+	}
+}
+
+func FuzzPackUnpack(f *testing.F) {
+	for _, seed := range []string{"", "aaaabccddddde", `qwe\\\\\45`, "ааааяя", "a11111111111111111111", "\n\n\u0301", "🇷🇺🇷🇺🇷"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !utf8.ValidString(s) {
+			t.Skip()
+		}
+		formats := []Format{
+			{Version: Version1},
+			{Version: Version2},
+			{Version: Version2, MaxCount: 12},
+			{Version: Version1, Graphemes: true},
+			{Version: Version2, Graphemes: true},
+		}
+		for _, format := range formats {
+			packed := format.Pack(s)
+			unpacked, err := format.Unpack(packed)
+			if err != nil {
+				t.Fatalf("Unpack(Pack(%q)) = %v", s, err)
+			}
+			if unpacked != s {
+				t.Fatalf("Unpack(Pack(%q)) = %q via %q", s, unpacked, packed)
+			}
+		}
+	})
+}
+
+func TestUnpackReader(t *testing.T) {
+	inputs := []string{"a4bc2d5e", "", "45", `qwe\45`, `qwe\\5`, `qwe\`, "я3ё2", "a\xffb3\xe2\x82", "a\xe2\x82\xac3"}
+	formats := []Format{{Version: Version1}, {Version: Version2}}
+	for _, format := range formats {
+		for _, input := range append(inputs, "a12b", "a0") {
+			expected, wantErr := format.Unpack(input)
+			// Побайтовое чтение проверяет руны, разрезанные между вызовами Write
+			for _, r := range []io.Reader{strings.NewReader(input), iotest.OneByteReader(strings.NewReader(input))} {
+				var out strings.Builder
+				err := format.UnpackReader(&out, r)
+				if (err != nil) != (wantErr != nil) {
+					t.Errorf("UnpackReader(%q): got error %v, want %v", input, err, wantErr)
+					continue
+				}
+				if err == nil && out.String() != expected {
+					t.Errorf("UnpackReader(%q) = %q, want %q", input, out.String(), expected)
+				}
+			}
+		}
+	}
+}
+
+func TestUnpackReaderOffsets(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abя45", "uncorrect string at rune 4 (byte 5): digit after repeat count '5'"},
+		{`qwe\`, "uncorrect string at rune 4 (byte 4): unterminated escape sequence"},
+		{"5", "uncorrect string at rune 0 (byte 0): digit at the start of the string '5'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			err := UnpackReader(io.Discard, strings.NewReader(test.input))
+			if err == nil || err.Error() != test.expected {
+				t.Errorf("UnpackReader(%q) error = %v, want %s", test.input, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnpackWriterClosed(t *testing.T) {
+	var out strings.Builder
+	w := NewUnpackWriter(&out)
+	if _, err := w.Write([]byte("a2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "aa" {
+		t.Errorf("got %q, want %q", out.String(), "aa")
+	}
+	if _, err := w.Write([]byte("b")); err == nil {
+		t.Error("expected error on write after Close")
+	}
+}
+
+// limitWriter принимает limit байт, а затем возвращает ошибку
+type limitWriter struct {
+	limit int
+}
+
+// errWriteLimit возвращается limitWriter после исчерпания лимита
+var errWriteLimit = errors.New("write limit exceeded")
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errWriteLimit
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+// TestUnpackWriterFailingWriter проверяет, что при ошибке нижележащего io.Writer
+// Write возвращает ее и не сообщает о большем числе байт, чем ему передано
+func TestUnpackWriterFailingWriter(t *testing.T) {
+	// Вывод больше буфера bufio, руны разрезаются между вызовами Write
+	input := []byte(strings.Repeat("яa9€2", 2000))
+	for _, format := range []Format{{Version: Version2}, {Version: Version2, Graphemes: true}} {
+		for chunk := 1; chunk <= 7; chunk++ {
+			for _, limit := range []int{0, 1, 4096, 10000} {
+				u := format.NewUnpackWriter(&limitWriter{limit: limit})
+				var err error
+				for off := 0; off < len(input) && err == nil; off += chunk {
+					p := input[off:minInt(off+chunk, len(input))]
+					var n int
+					n, err = u.Write(p)
+					if n < 0 || n > len(p) || n < len(p) && err == nil {
+						t.Fatalf("%+v, chunk %d, limit %d: Write(%d bytes) = %d, %v", format, chunk, limit, len(p), n, err)
+					}
+				}
+				if err == nil {
+					err = u.Close()
+				}
+				if !errors.Is(err, errWriteLimit) {
+					t.Errorf("%+v, chunk %d, limit %d: got %v, want %v", format, chunk, limit, err, errWriteLimit)
+				}
+				if _, err := u.Write([]byte("a")); !errors.Is(err, errWriteLimit) {
+					t.Errorf("Write after failure = %v, want %v", err, errWriteLimit)
+				}
+			}
+		}
+	}
+}
+
+func FuzzUnpackReader(f *testing.F) {
+	for _, seed := range []string{"a4bc2d5e", `qwe\45`, "я3\xe2\x82", "a12", "e\u03013🇷🇺2"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		for _, format := range []Format{{Version: Version2}, {Version: Version2, Graphemes: true}} {
+			expected, wantErr := format.Unpack(s)
+			var out strings.Builder
+			err := format.UnpackReader(&out, iotest.OneByteReader(strings.NewReader(s)))
+			if (err != nil) != (wantErr != nil) || out.String() != expected && err == nil {
+				t.Fatalf("UnpackReader(%q) = %q, %v; Unpack = %q, %v", s, out.String(), err, expected, wantErr)
+			}
+		}
+	})
+}
+
+func TestUnpackErrorKinds(t *testing.T) {
+	tests := []struct {
+		format Format
+		input  string
+		kind   ErrorKind
+		offset int
+		char   rune
+	}{
+		{Format{Version: Version1}, "45", LeadingDigit, 0, '4'},
+		{Format{Version: Version1}, "a12", RepeatedDigit, 2, '2'},
+		{Format{Version: Version1}, "яa12", RepeatedDigit, 3, '2'},
+		{Format{Version: Version1}, `qw\e`, BadEscape, 3, 'e'},
+		{Format{Version: Version1}, `qwe\`, UnterminatedEscape, 4, 0},
+		{Format{Version: Version2}, "a05", ZeroCount, 1, '0'},
+		{Format{Version: Version2}, "a0", ZeroCount, 1, '0'},
+		{Format{Version: Version2}, "ab4097", CountTooLarge, 5, '7'},
+		{Format{Version: Version2, MaxCount: 5}, "a7", CountTooLarge, 1, '7'},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := test.format.Unpack(test.input)
+			var unpackErr *UnpackError
+			if !errors.As(err, &unpackErr) {
+				t.Fatalf("Unpack(%s) error = %v, want *UnpackError", test.input, err)
+			}
+			if unpackErr.Kind != test.kind || unpackErr.Offset != test.offset || unpackErr.Rune != test.char {
+				t.Errorf("Unpack(%s) error = {%v %d %q}, want {%v %d %q}", test.input,
+					unpackErr.Kind, unpackErr.Offset, unpackErr.Rune, test.kind, test.offset, test.char)
+			}
+		})
+	}
+}
+
+func TestDiagnostic(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a4b12", "a4b12\n    ^ digit after repeat count"},
+		{"яя\t12", "яя\t12\n  \t ^ digit after repeat count"},
+		{`ab\`, "ab\\\n   ^ unterminated escape sequence"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			_, err := Unpack(test.input)
+			if result := Diagnostic(test.input, err); result != test.expected {
+				t.Errorf("Diagnostic(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+
+	if result := Diagnostic("x", io.ErrUnexpectedEOF); result != io.ErrUnexpectedEOF.Error() {
+		t.Errorf("Diagnostic of other error = %q", result)
+	}
+}
+
+func TestUnpackGraphemes(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"combining acute", "e\u03013", "e\u0301e\u0301e\u0301", false},
+		{"several marks", "a\u0308\u03042b", "a\u0308\u0304a\u0308\u0304b", false},
+		{"flag", "🇷🇺3", "🇷🇺🇷🇺🇷🇺", false},
+		{"flags in a row", "🇷🇺🇩🇪2", "🇷🇺🇩🇪🇩🇪", false},
+		{"skin tone", "👍🏽2", "👍🏽👍🏽", false},
+		{"zwj sequence", "👩\u200d💻2", "👩\u200d💻👩\u200d💻", false},
+		{"hangul syllable", "\u1100\u11612", "\u1100\u1161\u1100\u1161", false},
+		{"keycap is not a digit", "a1\ufe0f\u20e3", "a1\ufe0f\u20e3", false},
+		{"escaped digit", `\45`, "44444", false},
+		{"crlf", "\r\n2", "\r\n\r\n", false},
+		{"leading digit", "3e\u0301", "", true},
+		{"escaped cluster", "\\e\u0301", "", true},
+	}
+
+	format := Format{Version: Version1, Graphemes: true}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := format.Unpack(test.input)
+
+			if (err != nil) != test.wantErr {
+				t.Errorf("Unexpected error status: got %v, want %v", err, test.wantErr)
+				return
+			}
+
+			if result != test.expected {
+				t.Errorf("Unpack(%q) = %q, want %q", test.input, result, test.expected)
+			}
+		})
+	}
+
+	// Без режима графем число повторяет только последнюю руну
+	if result, _ := Unpack("e\u03013"); result != "e\u0301\u0301\u0301" {
+		t.Errorf("Unpack without graphemes = %q", result)
+	}
+}
+
+func TestPackGraphemes(t *testing.T) {
+	format := Format{Version: Version1, Graphemes: true}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"e\u0301e\u0301e\u0301", "e\u03013"},
+		{"🇷🇺🇷🇺🇷", "🇷🇺2🇷"},
+		{"👍🏽👍🏽👍", "👍🏽2👍"},
+		{"\n\n\u0301", "\n\n\u0301"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			result := format.Pack(test.input)
+			if result != test.expected {
+				t.Errorf("Pack(%q) = %q, want %q", test.input, result, test.expected)
+			}
+			if unpacked, err := format.Unpack(result); err != nil || unpacked != test.input {
+				t.Errorf("Unpack(%q) = %q, %v", result, unpacked, err)
+			}
+		})
+	}
+}
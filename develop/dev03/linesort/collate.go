@@ -0,0 +1,95 @@
+package linesort
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+// ParseLocale разбирает имя локали вида ru, ru_RU или ru_RU.UTF-8.
+// Для C и POSIX возвращает false: строки сравниваются побайтно
+func ParseLocale(name string) (language.Tag, bool, error) {
+	if name == "" || name == "C" || name == "POSIX" || strings.HasPrefix(name, "C.") {
+		return language.Und, false, nil
+	}
+	// Кодировку и модификатор отбрасываем, данные всегда считаются UTF-8
+	if i := strings.IndexAny(name, ".@"); i >= 0 {
+		name = name[:i]
+	}
+	tag, err := language.Parse(strings.ReplaceAll(name, "_", "-"))
+	if err != nil {
+		return language.Und, false, fmt.Errorf("invalid locale %q: %v", name, err)
+	}
+	return tag, true, nil
+}
+
+// textCollator вычисляет ключи сравнения строк по правилам Unicode Collation Algorithm
+// для локали. Не безопасен для одновременного использования из нескольких горутин
+type textCollator struct {
+	exact  *collate.Collator
+	folded *collate.Collator
+	buf    collate.Buffer
+}
+
+// newCollatorPool создает пул сортировщиков для локали: каждая горутина
+// берет свой textCollator
+func newCollatorPool(tag language.Tag) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return &textCollator{
+				exact:  collate.New(tag),
+				folded: collate.New(tag, collate.IgnoreCase),
+			}
+		},
+	}
+}
+
+// key возвращает ключ сравнения текста: ключи можно сравнивать побайтно.
+// С fold регистр букв не учитывается
+func (c *textCollator) key(text string, fold bool) []byte {
+	collator := c.exact
+	if fold {
+		collator = c.folded
+	}
+	key := append([]byte{}, collator.KeyFromString(&c.buf, text)...)
+	c.buf.Reset()
+	return key
+}
+
+// dictionaryText оставляет в тексте только пробелы, буквы и цифры, как sort -d.
+// В локали C буквами и цифрами считаются только ASCII, иначе - все буквы и цифры Unicode
+func dictionaryText(text string, unicodeLetters bool) string {
+	keep := func(r rune) bool {
+		if r == ' ' || r == '\t' {
+			return true
+		}
+		if unicodeLetters {
+			return unicode.IsLetter(r) || unicode.IsDigit(r)
+		}
+		return r < utf8.RuneSelf && (isDigit(byte(r)) || toUpper(byte(r)) >= 'A' && toUpper(byte(r)) <= 'Z')
+	}
+	if strings.IndexFunc(text, func(r rune) bool { return !keep(r) }) < 0 {
+		return text
+	}
+	if !unicodeLetters {
+		// Побайтно, чтобы не менять байты невалидного UTF-8 на U+FFFD
+		var b strings.Builder
+		for i := 0; i < len(text); i++ {
+			if keep(rune(text[i])) {
+				b.WriteByte(text[i])
+			}
+		}
+		return b.String()
+	}
+	return strings.Map(func(r rune) rune {
+		if keep(r) {
+			return r
+		}
+		return -1
+	}, text)
+}
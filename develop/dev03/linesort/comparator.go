@@ -0,0 +1,214 @@
+// Package linesort - строительные блоки утилиты sort: выделение ключей из строк,
+// порядки сравнения ключей, их комбинации и сортировка строк, в том числе
+// параллельная и внешняя, через временные файлы.
+//
+// Сравнение строк собирается из частей, например по второму полю как по числу,
+// а при равенстве - по всей строке в обратном порядке:
+//
+//	compare := linesort.Chain(
+//		linesort.On(linesort.Field(2, linesort.Blanks), linesort.Numeric()),
+//		linesort.Reverse(linesort.On(linesort.WholeLine, linesort.Lexical())),
+//	)
+//	sorter := &linesort.Sorter{Compare: compare}
+//	lines = sorter.Sort(lines)
+//
+// Если сравнение собрано только из On, Reverse, Chain и Ordering, ключи каждой строки
+// при сортировке разбираются один раз, а не при каждом сравнении
+package linesort
+
+import (
+	"sync"
+
+	"golang.org/x/text/language"
+)
+
+// Comparator сравнивает две строки и возвращает отрицательное число, если a меньше b,
+// ноль, если строки равны, и положительное число, если a больше b
+type Comparator interface {
+	Compare(a, b string) int
+}
+
+// ComparatorFunc позволяет использовать функцию как Comparator
+type ComparatorFunc func(a, b string) int
+
+// Compare возвращает f(a, b)
+func (f ComparatorFunc) Compare(a, b string) int {
+	return f(a, b)
+}
+
+// KeyExtractor выделяет из строки ключ сортировки
+type KeyExtractor func(line string) string
+
+// WholeLine выделяет в качестве ключа всю строку
+func WholeLine(line string) string {
+	return line
+}
+
+// Ordering порядок сравнения текстов ключей. Порядки создаются функциями Lexical,
+// Numeric, Human и Month и уточняются методами Fold, Dictionary и Collate.
+// Ordering сам является Comparator, сравнивающим строки целиком
+type Ordering struct {
+	options keyOptions
+	// collators - сортировщики локали, nil для побайтного сравнения
+	collators *sync.Pool
+}
+
+// Lexical возвращает побайтный порядок, как sort в локали C
+func Lexical() Ordering {
+	return Ordering{}
+}
+
+// Numeric возвращает порядок по числу в начале текста, как sort -n
+func Numeric() Ordering {
+	return Ordering{options: keyOptions{numeric: true}}
+}
+
+// Human возвращает порядок по числу с суффиксом вроде 2K или 1G, как sort -h
+func Human() Ordering {
+	return Ordering{options: keyOptions{human: true}}
+}
+
+// Month возвращает порядок по названию месяца, английскому или русскому, как sort -M
+func Month() Ordering {
+	return Ordering{options: keyOptions{month: true}}
+}
+
+// Fold возвращает порядок без учета регистра букв, как sort -f
+func (o Ordering) Fold() Ordering {
+	o.options.fold = true
+	return o
+}
+
+// Dictionary возвращает порядок, учитывающий только пробелы, буквы и цифры, как sort -d.
+// На числовые порядки и порядок месяцев не влияет
+func (o Ordering) Dictionary() Ordering {
+	o.options.dictionary = true
+	return o
+}
+
+// Collate возвращает порядок по правилам локали tag (Unicode Collation Algorithm)
+// вместо побайтного. На числовые порядки и порядок месяцев не влияет
+func (o Ordering) Collate(tag language.Tag) Ordering {
+	o.collators = newCollatorPool(tag)
+	return o
+}
+
+// Compare сравнивает тексты a и b
+func (o Ordering) Compare(a, b string) int {
+	collator := o.getCollator()
+	defer o.putCollator(collator)
+	x, y := newKeyValue(a, o.options, collator), newKeyValue(b, o.options, collator)
+	return compareKeyValues(&x, &y, o.options)
+}
+
+// parse разбирает текст ключа для многократного сравнения
+func (o Ordering) parse(text string) keyValue {
+	collator := o.getCollator()
+	defer o.putCollator(collator)
+	return newKeyValue(text, o.options, collator)
+}
+
+// getCollator возвращает сортировщик локали для текущей горутины или nil
+func (o Ordering) getCollator() *textCollator {
+	if o.collators == nil {
+		return nil
+	}
+	return o.collators.Get().(*textCollator)
+}
+
+// putCollator возвращает сортировщик, полученный от getCollator
+func (o Ordering) putCollator(collator *textCollator) {
+	if collator != nil {
+		o.collators.Put(collator)
+	}
+}
+
+// stage часть составного сравнения: ключ, порядок его сравнения и направление
+type stage struct {
+	extract  KeyExtractor
+	ordering Ordering
+	reverse  bool
+}
+
+// compare сравнивает разобранные ключи
+func (s *stage) compare(a, b *keyValue) int {
+	diff := compareKeyValues(a, b, s.ordering.options)
+	if s.reverse {
+		return -diff
+	}
+	return diff
+}
+
+// chain сравнение по ключам: следующий ключ сравнивается, только если предыдущие равны.
+// Ключи строки можно разобрать заранее
+type chain []stage
+
+// Compare сравнивает строки по ключам
+func (c chain) Compare(a, b string) int {
+	for _, s := range c {
+		x, y := s.ordering.parse(s.extract(a)), s.ordering.parse(s.extract(b))
+		if diff := s.compare(&x, &y); diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
+
+// stagesOf возвращает ключи сравнения, если оно собрано из частей пакета
+func stagesOf(c Comparator) (chain, bool) {
+	switch c := c.(type) {
+	case chain:
+		return c, true
+	case Ordering:
+		return chain{{extract: WholeLine, ordering: c}}, true
+	}
+	return nil, false
+}
+
+// On сравнивает строки по ключам, выделенным extract, в порядке ordering
+func On(extract KeyExtractor, ordering Ordering) Comparator {
+	return chain{{extract: extract, ordering: ordering}}
+}
+
+// Reverse обращает порядок сравнения c
+func Reverse(c Comparator) Comparator {
+	stages, ok := stagesOf(c)
+	if !ok {
+		return ComparatorFunc(func(a, b string) int {
+			return -c.Compare(a, b)
+		})
+	}
+	reversed := make(chain, len(stages))
+	for i, s := range stages {
+		s.reverse = !s.reverse
+		reversed[i] = s
+	}
+	return reversed
+}
+
+// Chain сравнивает строки первым сравнением, при равенстве - вторым, и так далее.
+// Без сравнений все строки равны
+func Chain(comparators ...Comparator) Comparator {
+	var stages chain
+	for _, c := range comparators {
+		s, ok := stagesOf(c)
+		if !ok {
+			return comparatorList(append([]Comparator(nil), comparators...))
+		}
+		stages = append(stages, s...)
+	}
+	return stages
+}
+
+// comparatorList цепочка сравнений, среди которых есть сравнения не из пакета
+type comparatorList []Comparator
+
+// Compare возвращает результат первого сравнения, различившего строки
+func (l comparatorList) Compare(a, b string) int {
+	for _, c := range l {
+		if diff := c.Compare(a, b); diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
@@ -0,0 +1,251 @@
+package linesort
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// keyValue ключ строки, разобранный заранее, чтобы не повторять разбор при каждом сравнении
+type keyValue struct {
+	text string
+	// number - число для модификаторов n и h
+	number number
+	// order - номер месяца для M или порядок суффикса для h
+	order int
+	// collated - текст сравнивается по ключу сравнения локали sortKey, а не побайтно
+	collated bool
+	sortKey  []byte
+}
+
+// newKeyValue разбирает текст ключа с модификаторами options.
+// Если collator не nil, для текста вычисляется ключ сравнения локали
+func newKeyValue(text string, options keyOptions, collator *textCollator) keyValue {
+	// Модификатор d действует только на текстовое сравнение
+	if options.dictionary && !options.numeric && !options.human && !options.month {
+		text = dictionaryText(text, collator != nil)
+	}
+	value := keyValue{text: text}
+	switch {
+	case options.numeric:
+		value.number = parseNumber(text)
+	case options.human:
+		value.number = parseNumber(text)
+		value.order = value.number.unitOrder()
+	case options.month:
+		value.order = monthNumber(text)
+	case collator != nil:
+		value.collated = true
+		value.sortKey = collator.key(text, options.fold)
+	}
+	return value
+}
+
+// compareKeyValues сравнивает ключи a и b с модификаторами options
+// и возвращает -1, 0 или 1. Модификатор r здесь не учитывается
+func compareKeyValues(a, b *keyValue, options keyOptions) int {
+	switch {
+	case options.numeric:
+		return a.number.compare(&b.number)
+	case options.human:
+		// Сначала по суффиксу, затем по значению, как sort -h
+		if diff := compareInts(a.order, b.order); diff != 0 {
+			return diff
+		}
+		return a.number.compare(&b.number)
+	case options.month:
+		return compareInts(a.order, b.order)
+	case a.collated && b.collated:
+		return bytes.Compare(a.sortKey, b.sortKey)
+	case options.fold:
+		return compareFolded(a.text, b.text)
+	}
+	return strings.Compare(a.text, b.text)
+}
+
+// compareInts сравнивает два числа
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// trimBlanks пропускает пробелы в начале строки
+func trimBlanks(s string) string {
+	i := 0
+	for i < len(s) && isBlank(s[i]) {
+		i++
+	}
+	return s[i:]
+}
+
+// number десятичное число из начала строки, разобранное без потери точности
+type number struct {
+	negative bool
+	// integer - целая часть без ведущих нулей, fraction - дробная без хвостовых
+	integer  string
+	fraction string
+	// magnitude - значение целой части, если в ней не больше 19 цифр: тогда целые части
+	// сравниваются как числа, не обращаясь к тексту строки
+	magnitude uint64
+	small     bool
+	// rest - остаток строки после числа
+	rest string
+}
+
+// parseNumber разбирает число вида [-]digits[.digits] после пробелов, как sort -n
+// в локали C. Строка без числа считается нулем
+func parseNumber(s string) number {
+	s = trimBlanks(s)
+	var n number
+	i := 0
+	if i < len(s) && s[i] == '-' {
+		n.negative = true
+		i++
+	}
+	start := i
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	n.integer = strings.TrimLeft(s[start:i], "0")
+	if len(n.integer) <= 19 {
+		n.small = true
+		for j := 0; j < len(n.integer); j++ {
+			n.magnitude = n.magnitude*10 + uint64(n.integer[j]-'0')
+		}
+	}
+	if i < len(s) && s[i] == '.' {
+		start = i + 1
+		i++
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		n.fraction = strings.TrimRight(s[start:i], "0")
+	}
+	// "-" без цифр и "-0" равны нулю
+	if n.isZero() {
+		n.negative = false
+	}
+	n.rest = s[i:]
+	return n
+}
+
+// isZero проверяет, что число равно нулю
+func (n number) isZero() bool {
+	return n.integer == "" && n.fraction == ""
+}
+
+// compareNumbers сравнивает числа в начале строк a и b
+func compareNumbers(a, b string) int {
+	x, y := parseNumber(a), parseNumber(b)
+	return x.compare(&y)
+}
+
+// compare сравнивает два числа
+func (n *number) compare(other *number) int {
+	if n.negative != other.negative {
+		if n.negative {
+			return -1
+		}
+		return 1
+	}
+	diff := compareMagnitudes(n, other)
+	if n.negative {
+		return -diff
+	}
+	return diff
+}
+
+// compareMagnitudes сравнивает абсолютные величины чисел: сначала по длине целой части,
+// затем по цифрам. Дробные части без хвостовых нулей можно сравнивать как строки
+func compareMagnitudes(a, b *number) int {
+	if a.small && b.small {
+		if a.magnitude != b.magnitude {
+			if a.magnitude < b.magnitude {
+				return -1
+			}
+			return 1
+		}
+	} else {
+		if diff := compareInts(len(a.integer), len(b.integer)); diff != 0 {
+			return diff
+		}
+		if diff := strings.Compare(a.integer, b.integer); diff != 0 {
+			return diff
+		}
+	}
+	if a.fraction == "" && b.fraction == "" {
+		return 0
+	}
+	return strings.Compare(a.fraction, b.fraction)
+}
+
+// Порядок суффиксов -h: 1K < 1M < 1G и т.д.
+var unitOrder = map[byte]int{
+	'K': 1, 'k': 1, 'M': 2, 'G': 3, 'T': 4, 'P': 5, 'E': 6, 'Z': 7, 'Y': 8, 'R': 9, 'Q': 10,
+}
+
+// unitOrder возвращает порядок суффикса числа, отрицательный для отрицательных чисел
+func (n number) unitOrder() int {
+	if n.isZero() || n.rest == "" {
+		return 0
+	}
+	order := unitOrder[n.rest[0]]
+	if n.negative {
+		return -order
+	}
+	return order
+}
+
+// Номера месяцев по первым трем буквам названия в нижнем регистре: английские,
+// как в локали C, и русские, включая родительный падеж ("мая")
+var monthPrefixes = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	"янв": 1, "фев": 2, "мар": 3, "апр": 4, "май": 5, "мая": 5, "июн": 6,
+	"июл": 7, "авг": 8, "сен": 9, "окт": 10, "ноя": 11, "дек": 12,
+}
+
+// monthNumber возвращает номер месяца (1-12), название которого стоит в начале строки,
+// или 0, если строка не начинается с названия месяца
+func monthNumber(s string) int {
+	s = trimBlanks(s)
+	// Отрезаем первые три руны
+	end, runes := 0, 0
+	for end < len(s) && runes < 3 {
+		_, size := utf8.DecodeRuneInString(s[end:])
+		end += size
+		runes++
+	}
+	if runes < 3 {
+		return 0
+	}
+	return monthPrefixes[strings.ToLower(s[:end])]
+}
+
+// compareFolded сравнивает строки побайтно, приводя латинские буквы к верхнему регистру
+func compareFolded(a, b string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if diff := compareInts(int(toUpper(a[i])), int(toUpper(b[i]))); diff != 0 {
+			return diff
+		}
+	}
+	return compareInts(len(a), len(b))
+}
+
+// toUpper переводит латинскую букву в верхний регистр
+func toUpper(c byte) byte {
+	if c >= 'a' && c <= 'z' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// isDigit проверяет, что байт - цифра
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
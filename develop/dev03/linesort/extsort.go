@@ -0,0 +1,312 @@
+package linesort
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Размер буфера сортировки по умолчанию
+const defaultBufferSize = 64 << 20
+
+// DefaultFanIn сколько временных файлов по умолчанию сливается за один проход
+const DefaultFanIn = 64
+
+// Примерные накладные расходы на хранение строки в слайсе, кроме ее байт
+const lineOverhead = 16
+
+// delimiter возвращает байт, завершающий запись
+func (s *Sorter) delimiter() byte {
+	return recordDelimiter(s.ZeroTerminated)
+}
+
+// ParseBufferSize разбирает размер буфера sort -S: число с суффиксом b, K, M, G или T.
+// Как и в GNU sort, число без суффикса означает килобайты
+func ParseBufferSize(s string) (int64, error) {
+	multiplier := int64(1 << 10)
+	digits := s
+	if s != "" && !isDigit(s[len(s)-1]) {
+		const units = "bKMGT"
+		unit := strings.IndexByte(units, s[len(s)-1])
+		if unit < 0 {
+			unit = strings.IndexByte(units, toUpper(s[len(s)-1]))
+		}
+		if unit < 0 {
+			return 0, fmt.Errorf("invalid buffer size %q", s)
+		}
+		multiplier = 1 << (10 * unit)
+		digits = s[:len(s)-1]
+	}
+	size, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || size <= 0 || size > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid buffer size %q", s)
+	}
+	return size * multiplier, nil
+}
+
+// SortStream читает строки из readers по очереди и пишет отсортированные в w.
+// Данные, не поместившиеся в буфер BufferSize, сортируются кусками во временных файлах
+// в TempDir, затем файлы сливаются k-путевым слиянием через кучу, по FanIn файлов за проход
+func (s *Sorter) SortStream(readers []io.Reader, w io.Writer) error {
+	var (
+		chunks []string
+		chunk  []string
+		size   int64
+	)
+	defer func() {
+		for _, name := range chunks {
+			os.Remove(name)
+		}
+	}()
+
+	bufferSize, fanIn := s.BufferSize, s.FanIn
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	if fanIn < 2 {
+		fanIn = DefaultFanIn
+	}
+	// Ключи строк разбираются заранее и тоже занимают память
+	overhead := int64(lineOverhead)
+	if stages, ok := stagesOf(s.Compare); ok {
+		overhead += int64(len(stages)) * keyValueOverhead
+	}
+	for _, r := range readers {
+		scanner := newLineScanner(r, s.delimiter())
+		for scanner.Scan() {
+			line := scanner.Text()
+			chunk = append(chunk, line)
+			size += int64(len(line)) + overhead
+			if size >= bufferSize {
+				name, err := s.writeChunk(chunk)
+				if err != nil {
+					return err
+				}
+				chunks = append(chunks, name)
+				chunk, size = nil, 0
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	// Все поместилось в память - временные файлы не нужны
+	if len(chunks) == 0 {
+		s.sortLines(chunk)
+		return s.writeLines(w, chunk)
+	}
+	if len(chunk) > 0 {
+		name, err := s.writeChunk(chunk)
+		if err != nil {
+			return err
+		}
+		chunks = append(chunks, name)
+	}
+
+	// Сливаем по fanIn файлов за проход, пока их не станет достаточно мало.
+	// Группы идут по порядку, поэтому устойчивость сохраняется
+	for len(chunks) > fanIn {
+		var merged []string
+		for start := 0; start < len(chunks); start += fanIn {
+			group := chunks[start:minInt(start+fanIn, len(chunks))]
+			name, err := s.mergeToFile(group)
+			if err != nil {
+				chunks = append(merged, chunks[start:]...)
+				return err
+			}
+			for _, old := range group {
+				os.Remove(old)
+			}
+			merged = append(merged, name)
+		}
+		chunks = merged
+	}
+	return s.mergeFiles(chunks, w)
+}
+
+// writeLines выводит строки, пропуская повторы в режиме Unique
+func (s *Sorter) writeLines(w io.Writer, lines []string) error {
+	writer := bufio.NewWriter(w)
+	for i, line := range lines {
+		if s.Unique && i > 0 && s.Compare.Compare(lines[i-1], line) == 0 {
+			continue
+		}
+		if err := writeRecord(writer, line, s.delimiter()); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// writeChunk сортирует кусок и сохраняет его во временный файл
+func (s *Sorter) writeChunk(chunk []string) (string, error) {
+	s.sortLines(chunk)
+	file, err := os.CreateTemp(s.TempDir, "sort-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := s.writeLines(file, chunk); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// mergeToFile сливает файлы в новый временный файл
+func (s *Sorter) mergeToFile(names []string) (string, error) {
+	file, err := os.CreateTemp(s.TempDir, "sort-*")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := s.mergeFiles(names, file); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// mergeFiles сливает отсортированные файлы в w
+func (s *Sorter) mergeFiles(names []string, w io.Writer) error {
+	readers := make([]io.Reader, 0, len(names))
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		readers = append(readers, file)
+	}
+	return s.Merge(readers, w)
+}
+
+// Merge сливает уже отсортированные потоки строк в w, как sort -m. При равенстве строк
+// первой выводится строка из более раннего потока
+func (s *Sorter) Merge(readers []io.Reader, w io.Writer) error {
+	h := &mergeHeap{compare: s.Compare}
+	for i, r := range readers {
+		scanner := newLineScanner(r, s.delimiter())
+		if scanner.Scan() {
+			h.items = append(h.items, mergeItem{line: scanner.Text(), source: i, scanner: scanner})
+		} else if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	heap.Init(h)
+
+	writer := bufio.NewWriter(w)
+	var prev string
+	written := false
+	for h.Len() > 0 {
+		item := &h.items[0]
+		if !s.Unique || !written || s.Compare.Compare(prev, item.line) != 0 {
+			if err := writeRecord(writer, item.line, s.delimiter()); err != nil {
+				return err
+			}
+			prev, written = item.line, true
+		}
+
+		if item.scanner.Scan() {
+			item.line = item.scanner.Text()
+			heap.Fix(h, 0)
+			continue
+		}
+		if err := item.scanner.Err(); err != nil {
+			return err
+		}
+		heap.Pop(h)
+	}
+	return writer.Flush()
+}
+
+// mergeItem очередная строка потока при слиянии
+type mergeItem struct {
+	line    string
+	source  int
+	scanner *bufio.Scanner
+}
+
+// mergeHeap куча строк потоков, упорядоченная по строке, затем по номеру потока
+type mergeHeap struct {
+	items   []mergeItem
+	compare Comparator
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	if diff := h.compare.Compare(h.items[i].line, h.items[j].line); diff != 0 {
+		return diff < 0
+	}
+	return h.items[i].source < h.items[j].source
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// ReadLines читает все записи из r, завершенные переводом строки
+// или, с zeroTerminated, нулевым байтом
+func ReadLines(r io.Reader, zeroTerminated bool) ([]string, error) {
+	var lines []string
+	scanner := newLineScanner(r, recordDelimiter(zeroTerminated))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// recordDelimiter возвращает байт, завершающий запись: перевод строки или нулевой байт для -z
+func recordDelimiter(zeroTerminated bool) byte {
+	if zeroTerminated {
+		return 0
+	}
+	return '\n'
+}
+
+// newLineScanner создает сканер записей, завершенных delimiter, без ограничения на длину записи.
+// В отличие от bufio.ScanLines, символ '\r' в конце записи сохраняется, как в GNU sort
+func newLineScanner(r io.Reader, delimiter byte) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, delimiter); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		// Последняя запись может быть не завершена
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	return scanner
+}
+
+// writeRecord пишет запись и завершающий ее байт
+func writeRecord(w *bufio.Writer, line string, delimiter byte) error {
+	if _, err := w.WriteString(line); err != nil {
+		return err
+	}
+	return w.WriteByte(delimiter)
+}
+
+// Наибольшая длина строки, которую может прочитать сканер
+const maxLineLength = 1 << 30
@@ -0,0 +1,355 @@
+package linesort
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyOptions модификаторы сравнения ключа, как в GNU sort
+type keyOptions struct {
+	// skipStartBlanks и skipEndBlanks - модификатор b у POS1 и POS2:
+	// пропустить пробелы в начале поля перед отсчетом символов
+	skipStartBlanks bool
+	skipEndBlanks   bool
+	// trimTrailingBlanks - пробелы в конце ключа не участвуют в сравнении (глобальный -b)
+	trimTrailingBlanks bool
+	numeric            bool
+	human              bool
+	month              bool
+	fold               bool
+	// dictionary - учитывать только пробелы, буквы и цифры (модификатор d)
+	dictionary bool
+	reverse    bool
+}
+
+// isDefault проверяет, что не задано ни одного модификатора, кроме r.
+// Такой ключ наследует глобальные модификаторы
+func (o keyOptions) isDefault() bool {
+	return !o.skipStartBlanks && !o.skipEndBlanks && !o.trimTrailingBlanks &&
+		!o.numeric && !o.human && !o.month && !o.fold && !o.dictionary
+}
+
+// validate проверяет, что модификаторы совместимы
+func (o keyOptions) validate() error {
+	if o.numeric && o.human || o.numeric && o.month || o.human && o.month {
+		return fmt.Errorf("options n, h and M are incompatible")
+	}
+	if o.dictionary && (o.numeric || o.human || o.month) {
+		return fmt.Errorf("option d is incompatible with n, h and M")
+	}
+	return nil
+}
+
+// keySpec определение ключа -k POS1[,POS2]. Поля и символы считаются с нуля
+type keySpec struct {
+	startField int
+	startChar  int
+	// endField -1 - ключ до конца строки, endChar 0 - до конца поля endField
+	endField int
+	endChar  int
+	keyOptions
+}
+
+// wholeLine ключ, занимающий всю строку
+func wholeLine(options keyOptions) keySpec {
+	return keySpec{endField: -1, keyOptions: options}
+}
+
+// Separator разделитель полей строки
+type Separator struct {
+	char byte
+	set  bool
+}
+
+// Blanks - поля отделяются переходом от непробельного символа к пробельному,
+// как в sort без -t. Пробелы в начале поля относятся к нему
+var Blanks = Separator{}
+
+// SeparatedBy возвращает разделитель полей c, как sort -t
+func SeparatedBy(c byte) Separator {
+	return Separator{char: c, set: true}
+}
+
+// Options модификаторы сравнения ключа, как флаги sort
+type Options struct {
+	// IgnoreBlanks - не учитывать пробелы в начале и в конце ключа (-b)
+	IgnoreBlanks bool
+	Numeric      bool
+	Human        bool
+	Month        bool
+	Fold         bool
+	Dictionary   bool
+	Reverse      bool
+}
+
+// keyOptions переводит модификаторы в модификаторы ключа
+func (o Options) keyOptions() keyOptions {
+	return keyOptions{
+		skipStartBlanks:    o.IgnoreBlanks,
+		skipEndBlanks:      o.IgnoreBlanks,
+		trimTrailingBlanks: o.IgnoreBlanks,
+		numeric:            o.Numeric,
+		human:              o.Human,
+		month:              o.Month,
+		fold:               o.Fold,
+		dictionary:         o.Dictionary,
+		reverse:            o.Reverse,
+	}
+}
+
+// Validate проверяет, что модификаторы совместимы
+func (o Options) Validate() error {
+	return o.keyOptions().validate()
+}
+
+// Key ключ сортировки: часть строки и модификаторы ее сравнения
+type Key struct {
+	spec keySpec
+}
+
+// ParseKey разбирает определение ключа sort -k вида F[.C][OPTS][,F[.C][OPTS]],
+// где OPTS - модификаторы bdfhMnr
+func ParseKey(spec string) (Key, error) {
+	key, err := parseKeySpec(spec)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{spec: key}, nil
+}
+
+// LineKey возвращает ключ, занимающий всю строку, с модификаторами options
+func LineKey(options Options) Key {
+	return Key{spec: wholeLine(options.keyOptions())}
+}
+
+// Inherit возвращает ключ с модификаторами global, если у ключа не задано
+// ни одного своего модификатора, как в GNU sort
+func (k Key) Inherit(global Options) Key {
+	if k.spec.isDefault() && !k.spec.reverse {
+		k.spec.keyOptions = global.keyOptions()
+	}
+	return k
+}
+
+// Extractor возвращает выделение ключа из строки с полями, разделенными sep
+func (k Key) Extractor(sep Separator) KeyExtractor {
+	spec := k.spec
+	return func(line string) string {
+		return spec.extract(line, sep.char, sep.set)
+	}
+}
+
+// Ordering возвращает порядок сравнения ключа
+func (k Key) Ordering() Ordering {
+	options := k.spec.keyOptions
+	// Пробелы учитываются при выделении ключа, а направление - в Reversed
+	options.skipStartBlanks, options.skipEndBlanks, options.trimTrailingBlanks = false, false, false
+	options.reverse = false
+	return Ordering{options: options}
+}
+
+// Reversed сообщает, задан ли у ключа обратный порядок
+func (k Key) Reversed() bool {
+	return k.spec.reverse
+}
+
+// Comparator возвращает сравнение строк по ключу
+func (k Key) Comparator(sep Separator) Comparator {
+	compare := On(k.Extractor(sep), k.Ordering())
+	if k.Reversed() {
+		return Reverse(compare)
+	}
+	return compare
+}
+
+// Field возвращает выделение поля n (с 1) строки с полями, разделенными sep
+func Field(n int, sep Separator) KeyExtractor {
+	return Key{spec: keySpec{startField: n - 1, endField: n - 1}}.Extractor(sep)
+}
+
+// parseKeySpec разбирает определение ключа вида F[.C][OPTS][,F[.C][OPTS]]
+func parseKeySpec(spec string) (keySpec, error) {
+	key := keySpec{endField: -1}
+	pos1, pos2, hasEnd := strings.Cut(spec, ",")
+
+	field, char, opts, err := parseKeyPosition(pos1)
+	if err != nil {
+		return keySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+	if field == 0 {
+		return keySpec{}, fmt.Errorf("invalid key %q: field number is zero", spec)
+	}
+	if char == 0 {
+		// Символ 0 в POS1 не имеет смысла, по умолчанию - первый символ
+		if strings.Contains(pos1, ".") {
+			return keySpec{}, fmt.Errorf("invalid key %q: character offset is zero", spec)
+		}
+		char = 1
+	}
+	key.startField, key.startChar = field-1, char-1
+	if err := key.setOptions(opts, true); err != nil {
+		return keySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+	}
+
+	if hasEnd {
+		field, char, opts, err := parseKeyPosition(pos2)
+		if err != nil {
+			return keySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+		}
+		if field == 0 {
+			return keySpec{}, fmt.Errorf("invalid key %q: field number is zero", spec)
+		}
+		key.endField, key.endChar = field-1, char
+		if err := key.setOptions(opts, false); err != nil {
+			return keySpec{}, fmt.Errorf("invalid key %q: %v", spec, err)
+		}
+	}
+	return key, nil
+}
+
+// parseKeyPosition разбирает позицию F[.C][OPTS]
+func parseKeyPosition(pos string) (field, char int, opts string, err error) {
+	i := 0
+	for i < len(pos) && pos[i] >= '0' && pos[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return 0, 0, "", fmt.Errorf("missing field number")
+	}
+	if field, err = strconv.Atoi(pos[:i]); err != nil {
+		return 0, 0, "", err
+	}
+	pos = pos[i:]
+
+	if strings.HasPrefix(pos, ".") {
+		i = 1
+		for i < len(pos) && pos[i] >= '0' && pos[i] <= '9' {
+			i++
+		}
+		if i == 1 {
+			return 0, 0, "", fmt.Errorf("missing character offset")
+		}
+		if char, err = strconv.Atoi(pos[1:i]); err != nil {
+			return 0, 0, "", err
+		}
+		pos = pos[i:]
+	}
+	return field, char, pos, nil
+}
+
+// setOptions применяет модификаторы, записанные после позиции.
+// Модификатор b относится к той позиции, после которой он записан
+func (k *keySpec) setOptions(opts string, start bool) error {
+	for _, opt := range opts {
+		switch opt {
+		case 'b':
+			if start {
+				k.skipStartBlanks = true
+			} else {
+				k.skipEndBlanks = true
+			}
+		case 'n':
+			k.numeric = true
+		case 'h':
+			k.human = true
+		case 'M':
+			k.month = true
+		case 'f':
+			k.fold = true
+		case 'd':
+			k.dictionary = true
+		case 'r':
+			k.reverse = true
+		default:
+			return fmt.Errorf("unknown modifier %q", opt)
+		}
+	}
+	return k.validate()
+}
+
+// isBlank проверяет, что байт - пробел или табуляция, как blanks в локали C
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// extract возвращает часть строки, занимаемую ключом. Без разделителя поля
+// отделяются переходом от непробельного символа к пробельному,
+// и пробелы в начале поля относятся к нему
+func (k keySpec) extract(line string, separator byte, hasSeparator bool) string {
+	start := k.begin(line, separator, hasSeparator)
+	end := len(line)
+	if k.endField >= 0 {
+		end = k.limit(line, separator, hasSeparator)
+	}
+	if end < start {
+		return ""
+	}
+	if k.trimTrailingBlanks {
+		for end > start && isBlank(line[end-1]) {
+			end--
+		}
+	}
+	return line[start:end]
+}
+
+// begin находит начало ключа
+func (k keySpec) begin(line string, separator byte, hasSeparator bool) int {
+	i := skipFields(line, 0, k.startField, separator, hasSeparator, true)
+	if k.skipStartBlanks {
+		for i < len(line) && isBlank(line[i]) {
+			i++
+		}
+	}
+	return minInt(len(line), i+k.startChar)
+}
+
+// limit находит конец ключа
+func (k keySpec) limit(line string, separator byte, hasSeparator bool) int {
+	field := k.endField
+	// Символ 0 означает конец поля: пропускаем и само поле
+	if k.endChar == 0 {
+		field++
+	}
+	i := skipFields(line, 0, field, separator, hasSeparator, k.endChar != 0)
+	if k.endChar != 0 {
+		if k.skipEndBlanks {
+			for i < len(line) && isBlank(line[i]) {
+				i++
+			}
+		}
+		i = minInt(len(line), i+k.endChar)
+	}
+	return i
+}
+
+// skipFields пропускает count полей начиная с позиции i. Если skipLastSeparator,
+// то пропускается и разделитель после последнего поля
+func skipFields(line string, i, count int, separator byte, hasSeparator, skipLastSeparator bool) int {
+	for ; i < len(line) && count > 0; count-- {
+		if hasSeparator {
+			for i < len(line) && line[i] != separator {
+				i++
+			}
+			if i < len(line) && (count > 1 || skipLastSeparator) {
+				i++
+			}
+			continue
+		}
+		for i < len(line) && isBlank(line[i]) {
+			i++
+		}
+		for i < len(line) && !isBlank(line[i]) {
+			i++
+		}
+	}
+	return i
+}
+
+// minInt возвращает меньшее из двух чисел
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
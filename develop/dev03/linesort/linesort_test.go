@@ -0,0 +1,363 @@
+package linesort
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseKeySpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected keySpec
+		wantErr  bool
+	}{
+		{"2", keySpec{startField: 1, endField: -1}, false},
+		{"2,2", keySpec{startField: 1, endField: 1}, false},
+		{"1.3,2.4", keySpec{startChar: 2, endField: 1, endChar: 4}, false},
+		{"3nr", keySpec{startField: 2, endField: -1, keyOptions: keyOptions{numeric: true, reverse: true}}, false},
+		{"1b,1b", keySpec{endField: 0, keyOptions: keyOptions{skipStartBlanks: true, skipEndBlanks: true}}, false},
+		{"2,3.0M", keySpec{startField: 1, endField: 2, keyOptions: keyOptions{month: true}}, false},
+		{"1f,1h", keySpec{keyOptions: keyOptions{fold: true, human: true}}, false},
+		{"0", keySpec{}, true},
+		{"1.0", keySpec{}, true},
+		{"1,0", keySpec{}, true},
+		{"a", keySpec{}, true},
+		{"1x", keySpec{}, true},
+		{"1.", keySpec{}, true},
+		{"1n,1M", keySpec{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			result, err := parseKeySpec(test.spec)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Unexpected error status: got %v, want %v", err, test.wantErr)
+			}
+			if err == nil && result != test.expected {
+				t.Errorf("parseKeySpec(%s) = %+v, want %+v", test.spec, result, test.expected)
+			}
+		})
+	}
+}
+
+func TestCompareNumbers(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"10", "9", 1},
+		{"-10", "-9", -1},
+		{"007", "7", 0},
+		{"-0", "0", 0},
+		{"-", "abc", 0},
+		{"  12.50", "12.5", 0},
+		{".5", "0.49", 1},
+		{"123456789012345678901234567890", "123456789012345678901234567889", 1},
+		{"-3.5", "-3", -1},
+		{"9999999999999999999", "10000000000000000000", -1},
+		{"18446744073709551615", "9999999999999999999", 1},
+		{"12.5", "12.05", 1},
+	}
+
+	for _, test := range tests {
+		if result := compareNumbers(test.a, test.b); result != test.expected {
+			t.Errorf("compareNumbers(%q, %q) = %d, want %d", test.a, test.b, result, test.expected)
+		}
+	}
+}
+
+func TestMonthNumber(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"Jan", 1},
+		{"  december 2020", 12},
+		{"SEPT", 9},
+		{"январь", 1},
+		{"  Марта", 3},
+		{"май", 5},
+		{"9 мая", 0},
+		{"мая 9", 5},
+		{"ДЕК.", 12},
+		{"ja", 0},
+		{"смена", 0},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		if result := monthNumber(test.input); result != test.expected {
+			t.Errorf("monthNumber(%q) = %d, want %d", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestExternalSortStable(t *testing.T) {
+	var input []string
+	for i := 0; i < 200; i++ {
+		input = append(input, fmt.Sprintf("%d %03d", i%7, i))
+	}
+	sorter := &Sorter{
+		// Сравниваем только по первому полю, порядок внутри группы должен сохраниться
+		Compare: ComparatorFunc(func(a, b string) int {
+			return strings.Compare(strings.Fields(a)[0], strings.Fields(b)[0])
+		}),
+		BufferSize: 100,
+		TempDir:    t.TempDir(),
+		FanIn:      3,
+	}
+
+	var out strings.Builder
+	if err := sorter.SortStream([]io.Reader{strings.NewReader(strings.Join(input, "\n"))}, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]string(nil), input...)
+	sort.SliceStable(expected, func(i, j int) bool {
+		return expected[i][0] < expected[j][0]
+	})
+	if result := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %q, want %q", result, expected)
+	}
+}
+
+func TestParseLocale(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		collated bool
+	}{
+		{"C", "und", false},
+		{"POSIX", "und", false},
+		{"C.UTF-8", "und", false},
+		{"ru", "ru", true},
+		{"ru_RU.UTF-8", "ru-RU", true},
+		{"en_US", "en-US", true},
+		{"de_DE@euro", "de-DE", true},
+	}
+
+	for _, test := range tests {
+		tag, collated, err := ParseLocale(test.name)
+		if err != nil {
+			t.Errorf("ParseLocale(%q): %v", test.name, err)
+			continue
+		}
+		if tag.String() != test.expected || collated != test.collated {
+			t.Errorf("ParseLocale(%q) = %v, %v, want %v, %v", test.name, tag, collated, test.expected, test.collated)
+		}
+	}
+}
+
+func TestDictionaryText(t *testing.T) {
+	tests := []struct {
+		input    string
+		unicode  bool
+		expected string
+	}{
+		{"co-op 2", false, "coop 2"},
+		{"O'Brien\tjr.", false, "OBrien\tjr"},
+		{"Вишня-2", false, "2"},
+		{"Вишня-2", true, "Вишня2"},
+		{"éclair!", true, "éclair"},
+		{"\xffab", false, "ab"},
+	}
+
+	for _, test := range tests {
+		if result := dictionaryText(test.input, test.unicode); result != test.expected {
+			t.Errorf("dictionaryText(%q, %v) = %q, want %q", test.input, test.unicode, result, test.expected)
+		}
+	}
+}
+
+func TestParseBufferSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"100", 100 << 10, false},
+		{"512b", 512, false},
+		{"64K", 64 << 10, false},
+		{"2m", 2 << 20, false},
+		{"1G", 1 << 30, false},
+		{"1T", 1 << 40, false},
+		{"", 0, true},
+		{"0", 0, true},
+		{"-5M", 0, true},
+		{"10X", 0, true},
+		{"M", 0, true},
+	}
+
+	for _, test := range tests {
+		result, err := ParseBufferSize(test.input)
+		if (err != nil) != test.wantErr || result != test.expected {
+			t.Errorf("ParseBufferSize(%q) = %d, %v; want %d", test.input, result, err, test.expected)
+		}
+	}
+}
+
+// TestParallelSortStable проверяет устойчивость слияния кусков
+func TestParallelSortStable(t *testing.T) {
+	items := make([]sortItem, 10*minParallelChunk)
+	for i := range items {
+		items[i].line = fmt.Sprintf("%d %06d", i%5, i)
+	}
+	expected := make([]string, 0, len(items))
+	for group := 0; group < 5; group++ {
+		for i := group; i < len(items); i += 5 {
+			expected = append(expected, items[i].line)
+		}
+	}
+
+	parallelSort(items, 4, func(a, b *sortItem) int {
+		return compareInts(int(a.line[0]), int(b.line[0]))
+	})
+	for i := range items {
+		if items[i].line != expected[i] {
+			t.Fatalf("item %d: got %q, want %q", i, items[i].line, expected[i])
+		}
+	}
+}
+
+// TestMergeSortStable проверяет сортировку слиянием куска на длинах около границ
+// отрезков, сортируемых вставками, и на уже упорядоченных данных
+func TestMergeSortStable(t *testing.T) {
+	byFirst := func(a, b *sortItem) int {
+		return compareInts(int(a.line[0]), int(b.line[0]))
+	}
+	for _, n := range []int{0, 1, 2, insertionRun - 1, insertionRun, insertionRun + 1, 2*insertionRun + 1, 100, 1000} {
+		for _, sorted := range []bool{false, true} {
+			items := make([]sortItem, n)
+			for i := range items {
+				group := (i * 7) % 3
+				if sorted {
+					group = i * 3 / n
+				}
+				items[i].line = fmt.Sprintf("%d %06d", group, i)
+			}
+			expected := make([]string, n)
+			for i := range items {
+				expected[i] = items[i].line
+			}
+			sort.SliceStable(expected, func(i, j int) bool {
+				return expected[i][0] < expected[j][0]
+			})
+
+			mergeSort(items, make([]sortItem, n), byFirst)
+			for i := range items {
+				if items[i].line != expected[i] {
+					t.Fatalf("n=%d sorted=%v, item %d: got %q, want %q", n, sorted, i, items[i].line, expected[i])
+				}
+			}
+		}
+	}
+}
+
+// TestComparators проверяет сравнения, собранные из частей пакета и из пользовательских функций
+func TestComparators(t *testing.T) {
+	lines := []string{"b 10 x", "a 9 y", "B 10 z", "c 9 Y", "a 10 x"}
+	byLength := ComparatorFunc(func(a, b string) int {
+		return len(a) - len(b)
+	})
+	russian, _, err := ParseLocale("ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		compare  Comparator
+		lines    []string
+		expected []string
+	}{
+		{"ordering", Lexical(), lines, []string{"B 10 z", "a 10 x", "a 9 y", "b 10 x", "c 9 Y"}},
+		{"numeric field", Chain(On(Field(2, Blanks), Numeric()), Lexical()), lines,
+			[]string{"a 9 y", "c 9 Y", "B 10 z", "a 10 x", "b 10 x"}},
+		{"reverse", Reverse(Chain(On(Field(2, Blanks), Numeric()), Lexical())), lines,
+			[]string{"b 10 x", "a 10 x", "B 10 z", "c 9 Y", "a 9 y"}},
+		{"fold", Chain(On(Field(1, Blanks), Lexical().Fold()), Reverse(On(Field(3, Blanks), Lexical()))), lines,
+			[]string{"a 9 y", "a 10 x", "B 10 z", "b 10 x", "c 9 Y"}},
+		{"separator", On(Field(2, SeparatedBy(':')), Numeric()), []string{"x:3", "y:-1", "z:2"},
+			[]string{"y:-1", "z:2", "x:3"}},
+		{"month", On(WholeLine, Month()), []string{"Mar", "jan", "дек", "Feb"}, []string{"jan", "Feb", "Mar", "дек"}},
+		{"human", Human(), []string{"1G", "10K", "2M", "512"}, []string{"512", "10K", "2M", "1G"}},
+		{"dictionary", Chain(Lexical().Dictionary(), Lexical()), []string{"c-a", "cb", "c.c"}, []string{"c-a", "cb", "c.c"}},
+		{"collate", Lexical().Collate(russian), []string{"ёж", "Ель", "ель", "жук", "еж"}, []string{"еж", "ёж", "ель", "Ель", "жук"}},
+		{"custom first", Chain(byLength, Lexical()), []string{"ccc", "b", "aa", "a"}, []string{"a", "b", "aa", "ccc"}},
+		{"custom last", Chain(Reverse(Lexical().Fold()), byLength), []string{"a", "B", "b", "A"}, []string{"B", "b", "a", "A"}},
+		{"custom reverse", Reverse(byLength), []string{"a", "ccc", "bb"}, []string{"ccc", "bb", "a"}},
+		{"empty chain", Chain(), []string{"b", "a", "c"}, []string{"b", "a", "c"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sorter := &Sorter{Compare: test.compare}
+			if result := sorter.Sort(append([]string(nil), test.lines...)); !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("Sort = %q, want %q", result, test.expected)
+			}
+			if line := sorter.Check(test.expected); line != 0 {
+				t.Errorf("Check(%q) = %d, want 0", test.expected, line)
+			}
+		})
+	}
+}
+
+// TestKeyComparator проверяет ключи sort -k и наследование глобальных модификаторов
+func TestKeyComparator(t *testing.T) {
+	lines := []string{"x:  2", "y: 10", "z:1"}
+	key, err := ParseKey("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key      Key
+		expected []string
+	}{
+		// Без -b пробелы входят в ключ и сравниваются побайтно
+		{key, []string{"x:  2", "y: 10", "z:1"}},
+		{key.Inherit(Options{IgnoreBlanks: true}), []string{"z:1", "y: 10", "x:  2"}},
+		{key.Inherit(Options{Numeric: true, Reverse: true}), []string{"y: 10", "x:  2", "z:1"}},
+		{LineKey(Options{Reverse: true}), []string{"z:1", "y: 10", "x:  2"}},
+	}
+
+	for _, test := range tests {
+		sorter := &Sorter{Compare: test.key.Comparator(SeparatedBy(':'))}
+		if result := sorter.Sort(append([]string(nil), lines...)); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%+v: got %q, want %q", test.key, result, test.expected)
+		}
+	}
+
+	// У ключа со своими модификаторами глобальные не применяются
+	own, err := ParseKey("2n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if own.Inherit(Options{Reverse: true}).Reversed() {
+		t.Errorf("key with own options inherited global reverse")
+	}
+}
+
+// TestSorterUnique проверяет, что Unique оставляет первую из равных строк во всех режимах
+func TestSorterUnique(t *testing.T) {
+	input := []string{"b 1", "a 2", "b 3", "a 4", "c 5"}
+	expected := []string{"a 2", "b 1", "c 5"}
+	sorter := &Sorter{Compare: On(Field(1, Blanks), Lexical()), Unique: true, BufferSize: 8, TempDir: t.TempDir(), FanIn: 2}
+
+	if result := sorter.Sort(append([]string(nil), input...)); !reflect.DeepEqual(result, expected) {
+		t.Errorf("Sort = %q, want %q", result, expected)
+	}
+	var out strings.Builder
+	if err := sorter.SortStream([]io.Reader{strings.NewReader(strings.Join(input, "\n"))}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != strings.Join(expected, "\n")+"\n" {
+		t.Errorf("SortStream = %q, want %q", out.String(), expected)
+	}
+	if line := sorter.Check([]string{"a 1", "a 2"}); line != 2 {
+		t.Errorf("Check with equal lines = %d, want 2", line)
+	}
+}
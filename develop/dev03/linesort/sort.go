@@ -0,0 +1,262 @@
+package linesort
+
+import (
+	"runtime"
+	"sync"
+)
+
+// Меньше стольких строк на горутину сортировать параллельно нет смысла
+const minParallelChunk = 4096
+
+// Отрезки такой длины сортировка слиянием сначала сортирует вставками
+const insertionRun = 24
+
+// Как и GNU sort, по умолчанию используем не больше 8 горутин
+const maxDefaultParallel = 8
+
+// Примерный размер разобранного ключа в памяти, для учета буфера внешней сортировки
+const keyValueOverhead = 128
+
+// DefaultParallel возвращает число горутин сортировки в памяти по умолчанию
+func DefaultParallel() int {
+	if n := runtime.GOMAXPROCS(0); n < maxDefaultParallel {
+		return n
+	}
+	return maxDefaultParallel
+}
+
+// Sorter сортирует строки в порядке Compare. Сортировка устойчива:
+// равные по Compare строки остаются в порядке ввода
+type Sorter struct {
+	Compare Comparator
+	// Unique - оставлять только первую из равных по Compare строк, как sort -u
+	Unique bool
+	// Parallel - число горутин сортировки в памяти, 0 - по числу процессоров, но не больше 8
+	Parallel int
+	// BufferSize, TempDir и FanIn - размер буфера, каталог временных файлов и число файлов,
+	// сливаемых за проход, для внешней сортировки SortStream. Нулевые значения - по умолчанию
+	BufferSize int64
+	TempDir    string
+	FanIn      int
+	// ZeroTerminated - записи в потоках завершаются нулевым байтом, а не переводом строки
+	ZeroTerminated bool
+}
+
+// parallel возвращает число горутин сортировки в памяти
+func (s *Sorter) parallel() int {
+	if s.Parallel > 0 {
+		return s.Parallel
+	}
+	return DefaultParallel()
+}
+
+// Sort сортирует lines на месте и возвращает их. С Unique из равных строк
+// остается только первая, и возвращается начало lines
+func (s *Sorter) Sort(lines []string) []string {
+	s.sortLines(lines)
+	if s.Unique {
+		return s.unique(lines)
+	}
+	return lines
+}
+
+// Check проверяет, что строки уже отсортированы, и возвращает номер (с 1) первой строки,
+// стоящей не на своем месте, или 0. С Unique равные строки тоже считаются нарушением
+func (s *Sorter) Check(lines []string) int {
+	for i := 1; i < len(lines); i++ {
+		diff := s.Compare.Compare(lines[i-1], lines[i])
+		if diff > 0 || diff == 0 && s.Unique {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// unique оставляет из подряд идущих равных строк только первую
+func (s *Sorter) unique(lines []string) []string {
+	result := lines[:0]
+	for i, line := range lines {
+		if i > 0 && s.Compare.Compare(result[len(result)-1], line) == 0 {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+// sortItem строка с заранее разобранными ключами
+type sortItem struct {
+	line string
+	keys []keyValue
+}
+
+// sortLines устойчиво сортирует строки на месте в Parallel горутинах. Если сравнение
+// собрано из частей пакета, ключи каждой строки разбираются один раз
+func (s *Sorter) sortLines(lines []string) {
+	stages, prepared := stagesOf(s.Compare)
+	items := make([]sortItem, len(lines))
+	if prepared {
+		prepareItems(items, lines, stages, s.parallel())
+	} else {
+		for i, line := range lines {
+			items[i].line = line
+		}
+	}
+
+	parallelSort(items, s.parallel(), func(a, b *sortItem) int {
+		if !prepared {
+			return s.Compare.Compare(a.line, b.line)
+		}
+		for k := range stages {
+			if diff := stages[k].compare(&a.keys[k], &b.keys[k]); diff != 0 {
+				return diff
+			}
+		}
+		return 0
+	})
+	for i := range items {
+		lines[i] = items[i].line
+	}
+}
+
+// prepareItems разбирает ключи всех строк в workers горутинах. Ключи хранятся
+// в одном общем слайсе, чтобы не выделять память под каждую строку
+func prepareItems(items []sortItem, lines []string, stages chain, workers int) {
+	values := make([]keyValue, len(lines)*len(stages))
+	forEachChunk(len(lines), workers, func(start, end int) {
+		for i := start; i < end; i++ {
+			item := &items[i]
+			item.line = lines[i]
+			item.keys = values[i*len(stages) : (i+1)*len(stages)]
+			for k, stage := range stages {
+				item.keys[k] = stage.ordering.parse(stage.extract(lines[i]))
+			}
+		}
+	})
+}
+
+// forEachChunk делит диапазон [0, n) на куски и обрабатывает их в workers горутинах
+func forEachChunk(n, workers int, process func(start, end int)) {
+	workers = chunkCount(n, workers)
+	if workers == 1 {
+		process(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			process(start, end)
+		}(w*n/workers, (w+1)*n/workers)
+	}
+	wg.Wait()
+}
+
+// chunkCount возвращает число кусков для параллельной обработки n элементов
+func chunkCount(n, workers int) int {
+	if limit := n / minParallelChunk; workers > limit {
+		workers = limit
+	}
+	if workers < 1 {
+		return 1
+	}
+	return workers
+}
+
+// parallelSort устойчиво сортирует items: куски сортируются слиянием в отдельных горутинах,
+// затем соседние куски попарно сливаются, пока не останется один
+func parallelSort(items []sortItem, workers int, compare func(a, b *sortItem) int) {
+	workers = chunkCount(len(items), workers)
+	bounds := make([]int, workers+1)
+	for w := range bounds {
+		bounds[w] = w * len(items) / workers
+	}
+	// Один буфер на все: куски сортируются каждый в своей части, затем в нем же сливаются
+	buf := make([]sortItem, len(items))
+	forEachChunk(len(items), workers, func(start, end int) {
+		mergeSort(items[start:end], buf[start:end], compare)
+	})
+	if workers == 1 {
+		return
+	}
+
+	src, dst := items, buf
+	for len(bounds) > 2 {
+		var wg sync.WaitGroup
+		merged := []int{0}
+		for i := 0; i+1 < len(bounds); i += 2 {
+			// Непарный последний кусок просто копируется
+			if i+2 >= len(bounds) {
+				copy(dst[bounds[i]:], src[bounds[i]:bounds[i+1]])
+				merged = append(merged, bounds[i+1])
+				continue
+			}
+			wg.Add(1)
+			go func(start, middle, end int) {
+				defer wg.Done()
+				mergeItems(dst[start:end], src[start:middle], src[middle:end], compare)
+			}(bounds[i], bounds[i+1], bounds[i+2])
+			merged = append(merged, bounds[i+2])
+		}
+		wg.Wait()
+		src, dst, bounds = dst, src, merged
+	}
+	if &src[0] != &items[0] {
+		copy(items, src)
+	}
+}
+
+// mergeItems сливает отсортированные left и right в dst. При равенстве
+// первым идет элемент из left, поэтому слияние устойчиво
+func mergeItems(dst, left, right []sortItem, compare func(a, b *sortItem) int) {
+	i, j, k := 0, 0, 0
+	for i < len(left) && j < len(right) {
+		if compare(&right[j], &left[i]) < 0 {
+			dst[k] = right[j]
+			j++
+		} else {
+			dst[k] = left[i]
+			i++
+		}
+		k++
+	}
+	k += copy(dst[k:], left[i:])
+	copy(dst[k:], right[j:])
+}
+
+// mergeSort устойчиво сортирует items слиянием снизу вверх, используя buf той же длины.
+// Короткие отрезки сначала сортируются вставками
+func mergeSort(items, buf []sortItem, compare func(a, b *sortItem) int) {
+	n := len(items)
+	for start := 0; start < n; start += insertionRun {
+		insertionSort(items[start:minInt(start+insertionRun, n)], compare)
+	}
+
+	src, dst := items, buf
+	for width := insertionRun; width < n; width *= 2 {
+		for start := 0; start < n; start += 2 * width {
+			middle, end := minInt(start+width, n), minInt(start+2*width, n)
+			// Уже упорядоченные соседние отрезки сливать не нужно
+			if middle == end || compare(&src[middle], &src[middle-1]) >= 0 {
+				copy(dst[start:end], src[start:end])
+				continue
+			}
+			mergeItems(dst[start:end], src[start:middle], src[middle:end], compare)
+		}
+		src, dst = dst, src
+	}
+	if n > 0 && &src[0] != &items[0] {
+		copy(items, src)
+	}
+}
+
+// insertionSort устойчиво сортирует короткий отрезок вставками
+func insertionSort(items []sortItem, compare func(a, b *sortItem) int) {
+	for i := 1; i < len(items); i++ {
+		for j := i; j > 0 && compare(&items[j], &items[j-1]) < 0; j-- {
+			items[j], items[j-1] = items[j-1], items[j]
+		}
+	}
+}
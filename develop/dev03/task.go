@@ -1,13 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
-	"sort"
-	"strconv"
 	"strings"
+
+	"github.com/43nvy/wb-level2/develop/dev03/linesort"
+	"github.com/43nvy/wb-level2/develop/internal/atomicfile"
+	"golang.org/x/text/language"
 )
 
 /*
@@ -37,150 +40,347 @@ import (
 
 // Получаем файл, флаги, открываем файл и действуем по флагам
 
+// SortCommand параметры сортировки. Глобальные модификаторы (-n, -h, -M, -f, -d, -b, -r)
+// применяются к ключам, у которых не задано своих модификаторов, или ко всей строке, если ключей нет
 type SortCommand struct {
-	inputFile   string
-	outputFile  string
-	keyColumn   int
-	numericSort bool
-	reverseSort bool
-	uniqueSort  bool
+	// inputFiles - файл -i и файлы, перечисленные после флагов. Пустой список или "-" - stdin
+	inputFiles []string
+	// outputFile - файл -o, по умолчанию вывод в stdout
+	outputFile string
+	keys       []linesort.Key
+	separator  linesort.Separator
+	// global - глобальные модификаторы
+	global linesort.Options
+	// uniqueSort - выводить только первую из строк с равными ключами
+	uniqueSort bool
+	// stableSort - не сравнивать строки с равными ключами целиком
+	stableSort  bool
+	mergeSorted bool
+	checkSorted bool
+	// zeroTerminated - записи завершаются нулевым байтом, а не переводом строки (-z)
+	zeroTerminated bool
+	// bufferSize, tempDir и fanIn - размер буфера, каталог временных файлов
+	// и число файлов, сливаемых за проход, для внешней сортировки
+	bufferSize int64
+	tempDir    string
+	fanIn      int
+	// parallel - число горутин сортировки в памяти
+	parallel int
+	// locale - локаль --locale, если collated, иначе строки сравниваются побайтно
+	locale   language.Tag
+	collated bool
 }
 
-type Command interface {
-	execute([]string) ([]string, error)
+// sorter создает сортировку с параметрами команды
+func (sc *SortCommand) sorter() *linesort.Sorter {
+	return &linesort.Sorter{
+		Compare:        sc.comparator(),
+		Unique:         sc.uniqueSort,
+		Parallel:       sc.parallel,
+		BufferSize:     sc.bufferSize,
+		TempDir:        sc.tempDir,
+		FanIn:          sc.fanIn,
+		ZeroTerminated: sc.zeroTerminated,
+	}
 }
 
-func (sc *SortCommand) execute(lines []string) ([]string, error) {
-	// Созадем копию слайса, с данными из прочитанного файла, с помощью append
-	slice := append([]string(nil), lines...)
-	// Инициализируем результирующий слайс, который вернем
-	resultSlice := lines[:sc.keyColumn]
-	// Смотрим заданные параметры и сортируем
-	if sc.keyColumn > 0 {
-		slice = slice[sc.keyColumn:]
+// comparator собирает сравнение строк, как в GNU sort: по ключам, а если все ключи
+// равны - по строке целиком. С -u и -s строки с равными ключами считаются равными
+func (sc *SortCommand) comparator() linesort.Comparator {
+	var comparators []linesort.Comparator
+	keys := sc.sortKeys()
+	for _, key := range keys {
+		ordering := key.Ordering()
+		if sc.collated {
+			ordering = ordering.Collate(sc.locale)
+		}
+		compare := linesort.On(key.Extractor(sc.separator), ordering)
+		if key.Reversed() {
+			compare = linesort.Reverse(compare)
+		}
+		comparators = append(comparators, compare)
 	}
 
-	if sc.uniqueSort {
-		slice = uniqueSlice(slice)
+	if len(keys) == 0 || !sc.uniqueSort && !sc.stableSort {
+		// Строка целиком сравнивается по правилам локали, а при равенстве - побайтно
+		var lastResort []linesort.Comparator
+		if sc.collated {
+			lastResort = append(lastResort, linesort.Lexical().Collate(sc.locale))
+		}
+		lastResort = append(lastResort, linesort.Lexical())
+		compare := linesort.Chain(lastResort...)
+		if sc.global.Reverse {
+			compare = linesort.Reverse(compare)
+		}
+		comparators = append(comparators, compare)
 	}
+	return linesort.Chain(comparators...)
+}
 
-	if sc.numericSort {
-		numericSort(slice)
-	} else {
-		sort.Slice(slice, func(i, j int) bool {
-			return slice[i] < slice[j]
-		})
+// sortKeys возвращает ключи сортировки с унаследованными глобальными модификаторами,
+// как в GNU sort: ключ без модификаторов получает глобальные
+func (sc *SortCommand) sortKeys() []linesort.Key {
+	if len(sc.keys) == 0 {
+		if sc.global == (linesort.Options{Reverse: sc.global.Reverse}) {
+			return nil
+		}
+		return []linesort.Key{linesort.LineKey(sc.global)}
 	}
 
-	if sc.reverseSort {
-		reverseSlice(slice)
+	keys := make([]linesort.Key, len(sc.keys))
+	for i, key := range sc.keys {
+		keys[i] = key.Inherit(sc.global)
 	}
-	// Добавляем отсортированный слайс к результирующему
-	resultSlice = append(resultSlice, slice...)
-	return resultSlice, nil
+	return keys
+}
+
+// keyFlag значение флага -k, который можно указать несколько раз
+type keyFlag []linesort.Key
+
+// String возвращает значение флага для вывода справки
+func (f *keyFlag) String() string {
+	return ""
 }
 
-func reverseSlice(slice []string) {
-	// Проходимся по всему слайсу и переприсваиваем значения
-	for i, j := 0, len(slice)-1; i < j; i, j = i+1, j-1 {
-		slice[i], slice[j] = slice[j], slice[i]
+// Set добавляет очередной ключ
+func (f *keyFlag) Set(value string) error {
+	key, err := linesort.ParseKey(value)
+	if err != nil {
+		return err
 	}
+	*f = append(*f, key)
+	return nil
 }
 
-func numericSort(slice []string) {
-	// Сортируем слайс с помощью стабильной сортировки из пакета sort
-	sort.SliceStable(slice, func(i, j int) bool {
-		// Так, как мы проверили обработали большинство ошибок, то
-		// при возникновении ошибки здесь - будет уместно завершить работу программы
-		numberI, err := searchNumberInString(slice[i])
-		if err != nil {
-			panic(err)
+// parseFlags разбирает аргументы командной строки
+func parseFlags(args []string) (*SortCommand, error) {
+	flags := flag.NewFlagSet("sort", flag.ContinueOnError)
+	// Ошибки выводит вызывающий код, справка возвращается в helpError
+	flags.SetOutput(io.Discard)
+	// Инициализируем флаги
+	var keys keyFlag
+	inputFileFlag := flags.String("i", "", "Input file path, in addition to file arguments")
+	outputFileFlag := flags.String("o", "", "Write result to this file instead of stdout; may be one of the inputs")
+	flags.Var(&keys, "k", "Sort key POS1[,POS2], where POS is F[.C][OPTS] and OPTS are bdfhMnr; may be repeated")
+	separatorFlag := flags.String("t", "", "Field separator instead of non-blank to blank transition")
+	numericFlag := flags.Bool("n", false, "Sort by numeric value")
+	humanFlag := flags.Bool("h", false, "Sort by numeric value with suffixes like 2K and 1G")
+	monthFlag := flags.Bool("M", false, "Sort by month name, English or Russian")
+	foldFlag := flags.Bool("f", false, "Ignore case")
+	dictionaryFlag := flags.Bool("d", false, "Consider only blanks, letters and digits")
+	localeFlag := flags.String("locale", "C", "Collation locale, e.g. ru_RU.UTF-8 or en; C and POSIX compare bytes")
+	blanksFlag := flags.Bool("b", false, "Ignore leading and trailing blanks")
+	reverseFlag := flags.Bool("r", false, "Reverse the order")
+	uniqueFlag := flags.Bool("u", false, "Output only the first of lines with equal keys")
+	stableFlag := flags.Bool("s", false, "Stable sort: keep input order of lines with equal keys")
+	mergeFlag := flags.Bool("m", false, "Merge already sorted files given as arguments")
+	checkFlag := flags.Bool("c", false, "Check whether input is sorted, report the first disorder")
+	zeroFlag := flags.Bool("z", false, "Line delimiter is NUL, not newline")
+	bufferSizeFlag := flags.String("S", "", "Main memory buffer size, e.g. 512M; larger inputs are sorted via temporary files")
+	tempDirFlag := flags.String("T", "", "Directory for temporary files instead of the system default")
+	batchSizeFlag := flags.Int("batch-size", linesort.DefaultFanIn, "Merge at most this many temporary files at once")
+	parallelFlag := flags.Int("parallel", linesort.DefaultParallel(), "Number of goroutines sorting in memory")
+	// Собираем флаги
+	err := flags.Parse(expandShortFlags(flags, args))
+	if err != nil {
+		if err == flag.ErrHelp {
+			var usage strings.Builder
+			flags.SetOutput(&usage)
+			flags.PrintDefaults()
+			return nil, &helpError{usage: usage.String()}
 		}
-		numberJ, err := searchNumberInString(slice[j])
+		return nil, err
+	}
+
+	sortCommand := &SortCommand{
+		inputFiles: flags.Args(),
+		outputFile: *outputFileFlag,
+		keys:       keys,
+		global: linesort.Options{
+			IgnoreBlanks: *blanksFlag,
+			Numeric:      *numericFlag,
+			Human:        *humanFlag,
+			Month:        *monthFlag,
+			Fold:         *foldFlag,
+			Dictionary:   *dictionaryFlag,
+			Reverse:      *reverseFlag,
+		},
+		uniqueSort:     *uniqueFlag,
+		stableSort:     *stableFlag,
+		mergeSorted:    *mergeFlag,
+		checkSorted:    *checkFlag,
+		zeroTerminated: *zeroFlag,
+		tempDir:        *tempDirFlag,
+		fanIn:          *batchSizeFlag,
+		parallel:       *parallelFlag,
+	}
+	if *inputFileFlag != "" {
+		sortCommand.inputFiles = append([]string{*inputFileFlag}, sortCommand.inputFiles...)
+	}
+	if sortCommand.checkSorted && sortCommand.mergeSorted {
+		return nil, fmt.Errorf("options -c and -m are incompatible")
+	}
+	if sortCommand.checkSorted && len(sortCommand.inputFiles) > 1 {
+		return nil, fmt.Errorf("extra operand %q not allowed with -c", sortCommand.inputFiles[1])
+	}
+	sortCommand.locale, sortCommand.collated, err = linesort.ParseLocale(*localeFlag)
+	if err != nil {
+		return nil, err
+	}
+	if sortCommand.parallel < 1 {
+		return nil, fmt.Errorf("invalid number of parallel sorts %d", sortCommand.parallel)
+	}
+	if sortCommand.fanIn < 2 {
+		return nil, fmt.Errorf("invalid batch size %d", sortCommand.fanIn)
+	}
+	if *bufferSizeFlag != "" {
+		size, err := linesort.ParseBufferSize(*bufferSizeFlag)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-		// Сортируем по возрастанию
-		return numberI < numberJ
-	})
+		sortCommand.bufferSize = size
+	}
+	if err := sortCommand.global.Validate(); err != nil {
+		return nil, err
+	}
+	switch {
+	case *separatorFlag == `\0`:
+		sortCommand.separator = linesort.SeparatedBy(0)
+	case len(*separatorFlag) == 1:
+		sortCommand.separator = linesort.SeparatedBy((*separatorFlag)[0])
+	case *separatorFlag != "":
+		return nil, fmt.Errorf("multi-character separator %q", *separatorFlag)
+	}
+	return sortCommand, nil
+}
 
+// helpError возвращается parseFlags, если запрошена справка
+type helpError struct {
+	usage string
 }
 
-func searchNumberInString(str string) (int, error) {
-	// Разбиваем строку на слова(токены)
-	tokens := strings.Fields(str)
-	// Рассматриваем краевой случай, если в строке одно слово
-	if len(tokens) < 2 {
-		return 0, nil
-	}
-	// Преобразовываем токен с числом в int
-	number, err := strconv.Atoi(tokens[len(tokens)-1])
-	if err != nil {
-		return 0, err
+// Error возвращает текст ошибки
+func (e *helpError) Error() string {
+	return "help requested"
+}
+
+// expandShortFlags приводит короткие флаги в стиле GNU к виду, понятному пакету flag:
+// "-k2,2n" превращается в "-k 2,2n", а "-nr" - в "-n -r"
+func expandShortFlags(flags *flag.FlagSet, args []string) []string {
+	var result []string
+	for i, arg := range args {
+		// После "--" флагов нет, оставляем аргументы как есть
+		if arg == "--" {
+			return append(result, args[i:]...)
+		}
+		name := strings.TrimPrefix(arg, "-")
+		if name == arg || strings.HasPrefix(name, "-") || len(name) < 2 ||
+			strings.Contains(name, "=") || flags.Lookup(name) != nil {
+			result = append(result, arg)
+			continue
+		}
+
+		var expanded []string
+		for j := 0; j < len(name); j++ {
+			f := flags.Lookup(name[j : j+1])
+			if f == nil {
+				expanded = nil
+				break
+			}
+			if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+				expanded = append(expanded, "-"+name[j:j+1])
+				continue
+			}
+			// Остаток аргумента - значение флага
+			expanded = append(expanded, "-"+name[j:j+1])
+			if j+1 < len(name) {
+				expanded = append(expanded, name[j+1:])
+			}
+			break
+		}
+		if expanded == nil {
+			expanded = []string{arg}
+		}
+		result = append(result, expanded...)
 	}
+	return result
+}
 
-	return number, nil
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
-func uniqueSlice(slice []string) []string {
-	uniqueMap := make(map[string]bool)
-	// Проходимся по слайсу и заносим строки в мапу
-	// Если строка повторяется - то ключ просто перезапишется
-	// Таким образом мы получим все уникальные строки
-	for _, item := range slice {
-		uniqueMap[item] = true
+// run выполняет sort с аргументами args как фильтр: читает файлы или stdin и пишет
+// в stdout или файл -o. Возвращает код выхода: 0 - успех, 1 - данные не отсортированы (-c),
+// 2 - ошибка, как в GNU sort
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	sortCommand, err := parseFlags(args)
+	if err != nil {
+		var help *helpError
+		if errors.As(err, &help) {
+			fmt.Fprintf(stdout, "Usage: sort [OPTION]... [FILE]...\n%s", help.usage)
+			return 0
+		}
+		fmt.Fprintf(stderr, "sort: %v\n", err)
+		return 2
 	}
-	// Создаем слайс, длина которого = 0, а обьем равен количеству элментов мапы,
-	// для предотвращения ненужных аллокаций
-	resultSlice := make([]string, 0, len(uniqueMap))
-	// Проходимся по мапе и аппендим ключи в результирующий слайс
-	for key := range uniqueMap {
-		resultSlice = append(resultSlice, key)
+	inputFiles := sortCommand.inputFiles
+	if len(inputFiles) == 0 {
+		inputFiles = []string{"-"}
 	}
 
-	return resultSlice
-}
+	// Открываем все входные файлы: они сортируются вместе или сливаются с -m
+	var inputs []io.Reader
+	for _, name := range inputFiles {
+		if name == "-" {
+			inputs = append(inputs, stdin)
+			continue
+		}
+		input, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(stderr, "sort: %v\n", err)
+			return 2
+		}
+		defer input.Close()
+		inputs = append(inputs, input)
+	}
 
-func main() {
-	// Инициализируем флаги
-	inputFileFlag := flag.String("i", "", "Input file path")
-	outputFileFlag := flag.String("o", "", "Output file path")
-	keyColumnFlag := flag.Int("k", 0, "Key column for sorting")
-	numericFlag := flag.Bool("n", false, "Sort by numeric value")
-	reverseFlag := flag.Bool("r", false, "Reverse the order")
-	uniqueFlag := flag.Bool("u", false, "Remove duplicate lines")
-	// Собираем флаги
-	flag.Parse()
-	// Читаем файл и записываем строки в слайс
-	lines, err := readFile(*inputFileFlag)
-	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
+	sorter := sortCommand.sorter()
+	// В режиме проверки только сообщаем о первом нарушении порядка, как GNU sort
+	if sortCommand.checkSorted {
+		lines, err := linesort.ReadLines(inputs[0], sortCommand.zeroTerminated)
+		if err != nil {
+			fmt.Fprintf(stderr, "sort: %s: %v\n", inputFiles[0], err)
+			return 2
+		}
+		if line := sorter.Check(lines); line > 0 {
+			fmt.Fprintf(stderr, "sort: %s:%d: disorder: %s\n", inputFiles[0], line, lines[line-1])
+			return 1
+		}
+		return 0
 	}
-	// Созадем обьект команды сортировки
-	sortCommand := &SortCommand{
-		inputFile:   *inputFileFlag,
-		outputFile:  *outputFileFlag,
-		keyColumn:   *keyColumnFlag,
-		numericSort: *numericFlag,
-		reverseSort: *reverseFlag,
-		uniqueSort:  *uniqueFlag,
-	}
-	// Вызываем функцию сортировки и передаем туда слайс
-	sortedLines, err := sortCommand.execute(lines)
-	if err != nil {
-		fmt.Printf("Error sorting lines: %v\n", err)
-		return
+
+	write := func(w io.Writer) error {
+		if sortCommand.mergeSorted {
+			return sorter.Merge(inputs, w)
+		}
+		// Большие входные данные сортируются через временные файлы
+		return sorter.SortStream(inputs, w)
+	}
+	if sortCommand.outputFile == "" {
+		err = write(stdout)
+	} else {
+		// Вывод пишется во временный файл, поэтому -o может совпадать с входным файлом
+		err = atomicfile.WriteFile(sortCommand.outputFile, write)
 	}
-	// Записываем отсортированный слайс в файл
-	err = writeToFile(*outputFileFlag, sortedLines)
 	if err != nil {
-		fmt.Printf("Error writing to output file: %v\n", err)
-		return
+		fmt.Fprintf(stderr, "sort: %v\n", err)
+		return 2
 	}
-
-	fmt.Printf("Sorted file: %s\n", *outputFileFlag)
+	return 0
 }
 
+// readFile читает строки файла
 func readFile(filename string) ([]string, error) {
 	// Открываем файл и проверяем на ошибку
 	file, err := os.Open(filename)
@@ -189,46 +389,6 @@ func readFile(filename string) ([]string, error) {
 	}
 	// Сразу откладываем закрытие файла
 	defer file.Close()
-	// Создаем срез строк из файла
-	// и сканнер
-	var lines []string
-	scanner := bufio.NewScanner(file)
-	// Запускаем цикл, который будет работать, пока есть непрочитанные строки
-	for scanner.Scan() {
-		// Записываем эти строки в слайс
-		lines = append(lines, scanner.Text())
-	}
-	// Проверяем на ошибки при чтении строк
-	if err := scanner.Err(); err != nil {
-		return nil, err
-	}
 
-	return lines, nil
-}
-
-func writeToFile(filename string, lines []string) error {
-	// Создаем файл и открываем его, а если файл с таким названием уже есть - перезаписываем
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	// Сразу откладываем закрытие файла
-	defer file.Close()
-	// Создаем writer, проходимся по слайсу строк и записываем каждую строку в буффер
-	// Буфер нам нужен для того, чтобы за 1 операцию записи, записать все строки
-	// Использование буфера необходима, при работе с большими обьемами данных
-	writer := bufio.NewWriter(file)
-	for _, line := range lines {
-		_, err := writer.WriteString(line + "\n")
-		if err != nil {
-			return err
-		}
-	}
-	// Теперь записываем все данные из буфера в файл
-	err = writer.Flush()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return linesort.ReadLines(file, false)
 }
@@ -1 +1,786 @@
 package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/43nvy/wb-level2/develop/dev03/linesort"
+	"github.com/43nvy/wb-level2/develop/internal/atomicfile"
+)
+
+// gnuCases аргументы и входные файлы, для которых вывод GNU sort в локали C
+// сохранен в testdata/<name>.golden
+var gnuCases = []struct {
+	name  string
+	input string
+	args  []string
+}{
+	{"fruits_default", "fruits.txt", []string{}},
+	{"fruits_reverse", "fruits.txt", []string{"-r"}},
+	{"fruits_numeric_key", "fruits.txt", []string{"-k2n"}},
+	{"fruits_name_then_count", "fruits.txt", []string{"-k1,1", "-k2,2nr"}},
+	{"fruits_numeric_line", "fruits.txt", []string{"-n"}},
+	{"table_age_name", "table.txt", []string{"-k2,2n", "-k1,1"}},
+	{"table_age_to_end", "table.txt", []string{"-k2n"}},
+	{"table_score", "table.txt", []string{"-k3,3n"}},
+	{"table_score_reverse_name", "table.txt", []string{"-k3,3nr", "-k1,1"}},
+	{"table_chars", "table.txt", []string{"-k1.2,1.3"}},
+	{"table_chars_blanks", "table.txt", []string{"-k1.2b,1.3b"}},
+	{"table_name_blanks", "table.txt", []string{"-k1b,1"}},
+	{"table_age_team", "table.txt", []string{"-k2,2", "-k4,4"}},
+	{"table_team_score", "table.txt", []string{"-k4,4", "-k3,3n"}},
+	{"table_fold", "table.txt", []string{"-k1,1f"}},
+	{"table_inherit", "table.txt", []string{"-n", "-r", "-k3,3"}},
+	{"table_own_reverse", "table.txt", []string{"-r", "-k2,2n"}},
+	{"passwd_uid", "passwd.txt", []string{"-t", ":", "-k3,3n"}},
+	{"passwd_gecos_name", "passwd.txt", []string{"-t", ":", "-k5,5", "-k1,1"}},
+	{"passwd_shell", "passwd.txt", []string{"-t", ":", "-k7", "-k1,1"}},
+	{"passwd_gid_uid", "passwd.txt", []string{"-t", ":", "-k4,4n", "-k3,3nr"}},
+	{"passwd_char", "passwd.txt", []string{"-t", ":", "-k6.7,6.8", "-k1,1"}},
+	{"sizes_human", "sizes.txt", []string{"-k1,1h"}},
+	{"sizes_numeric", "sizes.txt", []string{"-k1,1n"}},
+	{"sizes_human_reverse", "sizes.txt", []string{"-r", "-k1,1hr"}},
+	{"months", "months.txt", []string{"-k1,1M"}},
+	{"months_year", "months.txt", []string{"-k2,2n", "-k1,1M"}},
+	{"months_blanks", "months.txt", []string{"-k1.1b,1.3bf"}},
+	{"sizes_global_human", "sizes.txt", []string{"-h"}},
+	{"months_global", "months.txt", []string{"-M"}},
+	{"months_global_reverse", "months.txt", []string{"-M", "-r"}},
+	{"blanks_global", "blanks.txt", []string{"-b"}},
+	{"blanks_key", "blanks.txt", []string{"-k1b,1"}},
+	{"table_blanks_numeric", "table.txt", []string{"-b", "-k2,2", "-k3,3n"}},
+	{"fruits_unique", "fruits.txt", []string{"-u"}},
+	{"fruits_unique_name", "fruits.txt", []string{"-u", "-k1,1"}},
+	{"fruits_unique_name_reverse", "fruits.txt", []string{"-u", "-r", "-k1,1"}},
+	{"fruits_stable_name", "fruits.txt", []string{"-s", "-k1,1"}},
+	{"fruits_stable_count", "fruits.txt", []string{"-s", "-k2,2n"}},
+	{"table_unique_age", "table.txt", []string{"-u", "-k2,2n"}},
+	{"table_unique_team_reverse", "table.txt", []string{"-u", "-k4,4r"}},
+	{"table_stable_team", "table.txt", []string{"-s", "-k4,4"}},
+	{"table_unique_numeric_line", "table.txt", []string{"-u", "-n"}},
+	{"sizes_unique_human", "sizes.txt", []string{"-u", "-h"}},
+	{"words_en_fold", "words_en.txt", []string{"-f"}},
+	{"words_en_dictionary", "words_en.txt", []string{"-d"}},
+	{"words_en_fold_dictionary", "words_en.txt", []string{"-f", "-d"}},
+	{"words_en_key_fold_dictionary", "words_en.txt", []string{"-k1,1df"}},
+	{"words_en_unique_fold", "words_en.txt", []string{"-u", "-f"}},
+	{"words_ru_fold", "words_ru.txt", []string{"-f"}},
+	{"words_ru_dictionary", "words_ru.txt", []string{"-d"}},
+}
+
+// readGolden читает входной файл и ожидаемый результат случая из gnuCases
+func readGolden(t *testing.T, name, input string) ([]string, []string) {
+	t.Helper()
+	lines, err := readFile(filepath.Join("testdata", input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := readFile(filepath.Join("testdata", name+".golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines, expected
+}
+
+// TestGNUCompatibility сравнивает результат с выводом GNU sort
+func TestGNUCompatibility(t *testing.T) {
+	for _, test := range gnuCases {
+		t.Run(test.name, func(t *testing.T) {
+			sortCommand, err := parseFlags(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines, expected := readGolden(t, test.name, test.input)
+
+			result := sortCommand.sorter().Sort(lines)
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("sort %v %s:\ngot  %q\nwant %q", test.args, test.input, result, expected)
+			}
+		})
+	}
+}
+
+// gnuSort путь к GNU sort. Если задан, TestRandomGNU заново генерирует входные файлы
+// testdata/random и сохраняет вывод GNU sort в локали C:
+// go test -run TestRandomGNU -gnu-sort "$(command -v sort)"
+var gnuSort = flag.String("gnu-sort", "", "GNU sort binary to regenerate testdata/random")
+
+// randomArgs аргументы sort для случайных входных файлов, по одному набору на файл
+var randomArgs = [][]string{
+	{},
+	{"-r"},
+	{"-n"},
+	{"-nr"},
+	{"-h"},
+	{"-M"},
+	{"-f"},
+	{"-d"},
+	{"-fd"},
+	{"-b"},
+	{"-u"},
+	{"-fu"},
+	{"-k2,2"},
+	{"-k2,2n"},
+	{"-k2,2nr", "-k1,1"},
+	{"-k3,3h", "-k1,1f"},
+	{"-k1.2,1.4"},
+	{"-k2b,2"},
+	{"-b", "-k2,2", "-k3n"},
+	{"-t:", "-k2,2", "-k1,1r"},
+	{"-t:", "-k3,3n"},
+	{"-un", "-k2,2"},
+	{"-k1,1M", "-k2n"},
+	{"-r", "-k2,2d"},
+	{"-s", "-k2,2n"},
+	{"-su", "-k1,1f"},
+	{"-s", "-t:", "-k2,2"},
+	{"-nu"},
+	{"-hr"},
+	{"-k2.2b,2.3n"},
+}
+
+// randomInput генерирует строки из слов, чисел, размеров и месяцев, разделенных
+// пробелами, табуляциями и двоеточиями, с повторами и пустыми строками
+func randomInput(rnd *rand.Rand) []string {
+	words := []string{"apple", "Apple", "APPLE", "banana", "b-a-n", "x.y", "Zed", "zed", "_under", "a1", "A1", "#tag", "ab", "a b"}
+	months := []string{"jan", "Feb", "MAR", "april", "May", "jun", "Jul", "aug", "sep", "Oct", "nov", "DEC", "foo"}
+	units := []string{"", "", "K", "M", "G", "T", "k"}
+	blanks := []string{" ", " ", "  ", "\t", " \t"}
+
+	token := func() string {
+		switch rnd.Intn(5) {
+		case 0:
+			return words[rnd.Intn(len(words))]
+		case 1:
+			return strconv.Itoa(rnd.Intn(2001) - 1000)
+		case 2:
+			return fmt.Sprintf("%d.%d", rnd.Intn(100)-50, rnd.Intn(100))
+		case 3:
+			return strconv.Itoa(rnd.Intn(1000)) + units[rnd.Intn(len(units))]
+		default:
+			return months[rnd.Intn(len(months))]
+		}
+	}
+
+	lines := make([]string, 30+rnd.Intn(40))
+	for i := range lines {
+		switch {
+		case i > 0 && rnd.Intn(6) == 0:
+			lines[i] = lines[rnd.Intn(i)]
+			continue
+		case rnd.Intn(25) == 0:
+			continue
+		}
+
+		var line strings.Builder
+		if rnd.Intn(4) == 0 {
+			line.WriteString(blanks[rnd.Intn(len(blanks))])
+		}
+		for field := 1 + rnd.Intn(4); field > 0; field-- {
+			line.WriteString(token())
+			if field == 1 {
+				break
+			}
+			if rnd.Intn(3) == 0 {
+				line.WriteString(":")
+			} else {
+				line.WriteString(blanks[rnd.Intn(len(blanks))])
+			}
+		}
+		lines[i] = line.String()
+	}
+	return lines
+}
+
+// generateRandomCases пересоздает testdata/random по randomArgs с помощью GNU sort
+func generateRandomCases(t *testing.T, dir string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(21))
+	var manifest strings.Builder
+	for i, args := range randomArgs {
+		name := fmt.Sprintf("case%02d", i)
+		input := filepath.Join(dir, name+".txt")
+		if err := os.WriteFile(input, []byte(strings.Join(randomInput(rnd), "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(*gnuSort, append(append([]string(nil), args...), input)...)
+		cmd.Env = append(os.Environ(), "LC_ALL=C")
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s %v: %v", *gnuSort, args, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".golden"), output, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&manifest, "%s\t%s\n", name, strings.Join(args, " "))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "cases.txt"), []byte(manifest.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRandomGNU сравнивает сортировку случайных строк с выводом GNU sort из testdata/random
+// и проверяет свойства результата: это перестановка входа (без -u), она упорядочена
+// и не меняется при повторной и при внешней сортировке
+func TestRandomGNU(t *testing.T) {
+	dir := filepath.Join("testdata", "random")
+	if *gnuSort != "" {
+		generateRandomCases(t, dir)
+	}
+
+	manifest, err := readFile(filepath.Join(dir, "cases.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range manifest {
+		name, args, _ := strings.Cut(entry, "\t")
+		t.Run(name, func(t *testing.T) {
+			sortCommand, err := parseFlags(strings.Fields(args))
+			if err != nil {
+				t.Fatal(err)
+			}
+			input, expected := readGolden(t, filepath.Join("random", name), filepath.Join("random", name+".txt"))
+			sorter := sortCommand.sorter()
+
+			result := sorter.Sort(append([]string(nil), input...))
+			if !reflect.DeepEqual(result, expected) {
+				t.Fatalf("sort %s:\ngot  %q\nwant %q", args, result, expected)
+			}
+
+			if !sortCommand.uniqueSort {
+				got := append([]string(nil), result...)
+				want := append([]string(nil), input...)
+				sort.Strings(got)
+				sort.Strings(want)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("result is not a permutation of input")
+				}
+			}
+			if line := sorter.Check(result); line != 0 {
+				t.Errorf("Check reports disorder at line %d", line)
+			}
+			if again := sorter.Sort(append([]string(nil), result...)); !reflect.DeepEqual(again, result) {
+				t.Errorf("sorting the result again changed it:\ngot  %q\nwant %q", again, result)
+			}
+
+			sorter.BufferSize = 256
+			sorter.TempDir = t.TempDir()
+			sorter.FanIn = 2
+			var out strings.Builder
+			if err := sorter.SortStream([]io.Reader{strings.NewReader(strings.Join(input, "\n") + "\n")}, &out); err != nil {
+				t.Fatal(err)
+			}
+			if streamed := out.String(); streamed != strings.Join(expected, "\n")+"\n" {
+				t.Errorf("external sort:\ngot  %q\nwant %q", streamed, strings.Join(expected, "\n")+"\n")
+			}
+		})
+	}
+}
+
+func TestExpandShortFlags(t *testing.T) {
+	flags := flag.NewFlagSet("sort", flag.ContinueOnError)
+	flags.String("k", "", "")
+	flags.String("t", "", "")
+	flags.Bool("n", false, "")
+	flags.Bool("r", false, "")
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"-k2,2n"}, []string{"-k", "2,2n"}},
+		{[]string{"-nr", "-k", "1"}, []string{"-n", "-r", "-k", "1"}},
+		{[]string{"-nk2"}, []string{"-n", "-k", "2"}},
+		{[]string{"-t:", "file"}, []string{"-t", ":", "file"}},
+		{[]string{"-k=2", "-x"}, []string{"-k=2", "-x"}},
+		{[]string{"-nx"}, []string{"-nx"}},
+		{[]string{"--", "-nr"}, []string{"--", "-nr"}},
+	}
+
+	for _, test := range tests {
+		if result := expandShortFlags(flags, test.args); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("expandShortFlags(%q) = %q, want %q", test.args, result, test.expected)
+		}
+	}
+}
+
+func TestMonthSortMixed(t *testing.T) {
+	lines := []string{"Март 2021", "jan 2020", "дек 2019", "Feb 2021", "августа 2020", "апр", "unknown", "May 2019", "Июнь"}
+	expected := []string{"unknown", "jan 2020", "Feb 2021", "Март 2021", "апр", "May 2019", "Июнь", "августа 2020", "дек 2019"}
+
+	sortCommand, err := parseFlags([]string{"-M"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := sortCommand.sorter().Sort(lines)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %q, want %q", result, expected)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		args     []string
+		lines    []string
+		expected int
+	}{
+		{nil, []string{"a", "b", "b", "c"}, 0},
+		{nil, []string{"a", "c", "b"}, 3},
+		{[]string{"-r"}, []string{"c", "b", "a"}, 0},
+		{[]string{"-n"}, []string{"2", "10", "9"}, 3},
+		{[]string{"-h"}, []string{"10K", "2M", "1G"}, 0},
+		{[]string{"-M"}, []string{"фев", "jan"}, 2},
+		{[]string{"-k2,2n"}, []string{"x 1", "a 2", "b 2"}, 0},
+		{[]string{"-u"}, []string{"a", "b", "b"}, 3},
+		{[]string{"-u", "-k2,2n"}, []string{"x 1", "a 2", "b 3"}, 0},
+		{[]string{"-u", "-k2,2n"}, []string{"x 1", "b 2", "a 2"}, 3},
+		{[]string{"-s", "-k2,2n"}, []string{"x 1", "b 2", "a 2"}, 0},
+		{nil, nil, 0},
+	}
+
+	for _, test := range tests {
+		sortCommand, err := parseFlags(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := sortCommand.sorter().Check(test.lines); result != test.expected {
+			t.Errorf("check %v %q = %d, want %d", test.args, test.lines, result, test.expected)
+		}
+	}
+}
+
+func TestIncompatibleOptions(t *testing.T) {
+	for _, args := range [][]string{{"-n", "-h"}, {"-M", "-n"}, {"-hM"}, {"-c", "-m"}, {"-c", "a.txt", "b.txt"}, {"-d", "-n"}, {"-k1,1dM"}, {"--locale", "??"}} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("parseFlags(%q): expected error", args)
+		}
+	}
+}
+
+// TestExternalSort сортирует случаи gnuCases с буфером в несколько строк,
+// чтобы данные проходили через временные файлы и несколько проходов слияния
+func TestExternalSort(t *testing.T) {
+	for _, test := range gnuCases {
+		t.Run(test.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			args := append([]string{"-S", "64b", "-T", tempDir, "--batch-size", "2"}, test.args...)
+			sortCommand, err := parseFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines, expected := readGolden(t, test.name, test.input)
+
+			var out strings.Builder
+			if err := sortCommand.sorter().SortStream([]io.Reader{strings.NewReader(strings.Join(lines, "\n"))}, &out); err != nil {
+				t.Fatal(err)
+			}
+			if result := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !reflect.DeepEqual(result, expected) {
+				t.Errorf("sort %v %s:\ngot  %q\nwant %q", args, test.input, result, expected)
+			}
+
+			// Временные файлы должны быть удалены
+			if entries, err := os.ReadDir(tempDir); err != nil || len(entries) != 0 {
+				t.Errorf("temporary files left: %v %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestExternalSortUnique(t *testing.T) {
+	sortCommand, err := parseFlags([]string{"-u", "-S", "32b", "-T", t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	input := "b\na\nc\na\nb\nb\nc\na\nd\n"
+	if err := sortCommand.sorter().SortStream([]io.Reader{strings.NewReader(input)}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "a\nb\nc\nd\n" {
+		t.Errorf("got %q", out.String())
+	}
+}
+
+// TestCollation сортирует русские и английские слова по правилам локали
+func TestCollation(t *testing.T) {
+	tests := []struct {
+		input    string
+		args     []string
+		expected []string
+	}{
+		{"words_en.txt", []string{"--locale", "en_US.UTF-8"}, []string{
+			"10 apples", "2 apples", "apple", "Apple", "apple pie", "apple-pie", "banana", "Banana", "cherry",
+			"co-op", "coop", "eclair", "éclair", "O'Brien", "obrien", "zebra", "Zebra",
+		}},
+		{"words_en.txt", []string{"--locale", "en", "-r"}, []string{
+			"Zebra", "zebra", "obrien", "O'Brien", "éclair", "eclair", "coop", "co-op", "cherry",
+			"Banana", "banana", "apple-pie", "apple pie", "Apple", "apple", "2 apples", "10 apples",
+		}},
+		{"words_en.txt", []string{"--locale", "en", "-d"}, []string{
+			"10 apples", "2 apples", "apple", "Apple", "apple pie", "apple-pie", "banana", "Banana", "cherry",
+			"co-op", "coop", "eclair", "éclair", "obrien", "O'Brien", "zebra", "Zebra",
+		}},
+		{"words_ru.txt", []string{"--locale", "ru_RU.UTF-8"}, []string{
+			"apple", "Zoo", "арбуз", "Арбуз", "банан", "Банан", "вишня", "Вишня-2", "вишня!",
+			"еж", "ёж", "елка", "ёлка", "ель", "Ель", "жук", "яблоко", "Яблоко",
+		}},
+		// С -f строки, отличающиеся только регистром, равны, и -u оставляет первую из них
+		{"words_ru.txt", []string{"--locale", "ru", "-f", "-u"}, []string{
+			"apple", "Zoo", "Арбуз", "банан", "вишня", "Вишня-2", "вишня!",
+			"еж", "ёж", "елка", "ёлка", "Ель", "жук", "яблоко",
+		}},
+		{"words_ru.txt", []string{"--locale", "ru", "-k1,1d"}, []string{
+			"apple", "Zoo", "арбуз", "Арбуз", "банан", "Банан", "вишня", "вишня!", "Вишня-2",
+			"еж", "ёж", "елка", "ёлка", "ель", "Ель", "жук", "яблоко", "Яблоко",
+		}},
+	}
+
+	for _, test := range tests {
+		sortCommand, err := parseFlags(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, err := readFile(filepath.Join("testdata", test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := sortCommand.sorter().Sort(lines)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("sort %v %s:\ngot  %q\nwant %q", test.args, test.input, result, test.expected)
+		}
+		// Проверка и слияние используют то же сравнение
+		if line := sortCommand.sorter().Check(result); line != 0 {
+			t.Errorf("sort -c %v: disorder at line %d", test.args, line)
+		}
+	}
+}
+
+// TestMerge сливает отсортированные файлы и сравнивает результат с выводом GNU sort -m
+func TestMerge(t *testing.T) {
+	files := []string{"merge_a.txt", "merge_b.txt", "merge_c.txt"}
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"merge_numeric", []string{"-m", "-k2,2n"}},
+		{"merge_stable", []string{"-m", "-s", "-k2,2n"}},
+		{"merge_unique", []string{"-m", "-u", "-k2,2n"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := append(append([]string(nil), test.args...), files...)
+			sortCommand, err := parseFlags(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sortCommand.inputFiles, files) {
+				t.Fatalf("inputFiles = %q, want %q", sortCommand.inputFiles, files)
+			}
+
+			var inputs []io.Reader
+			for _, name := range files {
+				file, err := os.Open(filepath.Join("testdata", name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer file.Close()
+				inputs = append(inputs, file)
+			}
+			var out strings.Builder
+			if err := sortCommand.sorter().Merge(inputs, &out); err != nil {
+				t.Fatal(err)
+			}
+			expected, err := os.ReadFile(filepath.Join("testdata", test.name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != string(expected) {
+				t.Errorf("sort %v:\ngot  %q\nwant %q", args, out.String(), expected)
+			}
+		})
+	}
+}
+
+func TestWriteFileAtomicSameFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(filename, []byte("c\na\nb\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	sortCommand := &SortCommand{bufferSize: 2, tempDir: t.TempDir()}
+	err = atomicfile.WriteFile(filename, func(w io.Writer) error {
+		return sortCommand.sorter().SortStream([]io.Reader{input}, w)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "a\nb\nc\n" {
+		t.Errorf("got %q", data)
+	}
+}
+
+// TestWriteFileAtomicKeepsFile проверяет, что -o сохраняет права существующего файла
+// и заменяет файл, на который указывает символическая ссылка, а не саму ссылку
+func TestWriteFileAtomicKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private.txt")
+	if err := os.WriteFile(private, []byte("b\na\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(private, link); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	for _, filename := range []string{private, link} {
+		if code := run([]string{"-o", filename, filename}, strings.NewReader(""), io.Discard, io.Discard); code != 0 {
+			t.Fatalf("sort -o %s: exit code %d", filename, code)
+		}
+		info, err := os.Stat(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("sort -o %s: mode %v, want 0600", filename, info.Mode().Perm())
+		}
+	}
+
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("symlink was replaced: %v, %v", info, err)
+	}
+	if data, _ := os.ReadFile(private); string(data) != "a\nb\n" {
+		t.Errorf("got %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	// Новый файл создается с правами 0644 с учетом umask, а не 0600, как у os.CreateTemp
+	created := filepath.Join(dir, "new.txt")
+	if code := run([]string{"-o", created, private}, strings.NewReader(""), io.Discard, io.Discard); code != 0 {
+		t.Fatalf("sort -o %s: exit code %d", created, code)
+	}
+	probe := filepath.Join(t.TempDir(), "probe.txt")
+	if err := os.WriteFile(probe, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.Stat(probe)
+	if info, err := os.Stat(created); err != nil || info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("new file: %v, %v, want mode %v", info, err, want.Mode().Perm())
+	}
+}
+
+// randomLines генерирует n строк вида "слово число размер" для проверки и замеров сортировки
+func randomLines(n int) []string {
+	rnd := rand.New(rand.NewSource(1))
+	units := []string{"", "K", "M", "G"}
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("w%04x %d %d%s", rnd.Intn(1<<16), rnd.Intn(1000)-500, rnd.Intn(1024), units[rnd.Intn(len(units))])
+	}
+	return lines
+}
+
+// TestParallelSort проверяет, что параллельная сортировка с разобранными ключами
+// дает тот же результат, что и последовательная сортировка через Compare
+func TestParallelSort(t *testing.T) {
+	input := randomLines(20480)
+	for _, args := range [][]string{
+		{},
+		{"-r"},
+		{"-k2,2n"},
+		{"-k3,3h", "-k1,1r"},
+		{"-t", " ", "-k2n", "-r"},
+		{"-s", "-k2,2n"},
+		{"-u", "-k1,1"},
+		{"--locale", "ru", "-k1,1f"},
+	} {
+		sortCommand, err := parseFlags(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare := sortCommand.comparator()
+		expected := append([]string(nil), input...)
+		sort.SliceStable(expected, func(i, j int) bool {
+			return compare.Compare(expected[i], expected[j]) < 0
+		})
+
+		for _, parallel := range []int{1, 2, 3, 8} {
+			sorter := &linesort.Sorter{Compare: compare, Parallel: parallel}
+			result := sorter.Sort(append([]string(nil), input...))
+			if !reflect.DeepEqual(result, expected) {
+				t.Errorf("sort %v --parallel=%d: result differs from serial sort", args, parallel)
+			}
+		}
+	}
+}
+
+// Размер входных данных для бенчмарков. Сортировка 10 млн строк, как в задаче, занимает
+// около минуты на каждое значение --parallel и несколько ГБ памяти:
+//
+//	go test -run '^$' -bench Sort -benchtime 1x -benchmem -timeout 0 -bench-lines=10000000
+//
+// Результаты на 10 млн строк, Intel Xeon, linux/amd64, 1 ядро (GOMAXPROCS=1).
+// Машины с несколькими ядрами не было, поэтому ускорение от горутин здесь не измерено:
+// на одном ядре время при разных --parallel отличается в пределах разброса между
+// запусками, около 20%:
+//
+//	BenchmarkSortParallel/parallel=1    1    45.2 s/op   4640 MB/op   6 allocs/op
+//	BenchmarkSortParallel/parallel=2    1    56.4 s/op   4640 MB/op  27 allocs/op
+//	BenchmarkSortParallel/parallel=4    1    55.5 s/op   4640 MB/op  41 allocs/op
+//	BenchmarkSortParallel/parallel=8    1    40.7 s/op   4640 MB/op  76 allocs/op
+//	BenchmarkSortCompareLines           1   112.2 s/op    800 MB/op   4 allocs/op
+//
+// Разбор ключей заранее ускоряет сортировку в 2-2.5 раза. Для сравнения, на 1 млн строк
+// с -k2,2n -k3,3h эта сортировка вместе с чтением и записью занимает 6.4 с, GNU sort - 2.0 с
+var benchLines = flag.Int("bench-lines", 100000, "number of lines in sort benchmarks")
+
+// BenchmarkSortParallel сортирует строки с разобранными заранее ключами
+// в разном числе горутин
+func BenchmarkSortParallel(b *testing.B) {
+	input := randomLines(*benchLines)
+	for _, parallel := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallel=%d", parallel), func(b *testing.B) {
+			sortCommand, err := parseFlags([]string{"-k2,2n", "-k3,3h", "--parallel", fmt.Sprint(parallel)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			sorter := sortCommand.sorter()
+			lines := make([]string, len(input))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				copy(lines, input)
+				sorter.Sort(lines)
+			}
+		})
+	}
+}
+
+// BenchmarkSortCompareLines сортирует те же строки в одной горутине с разбором ключей
+// при каждом сравнении: сравнение, обернутое в ComparatorFunc, не разбирается заранее
+func BenchmarkSortCompareLines(b *testing.B) {
+	input := randomLines(*benchLines)
+	sortCommand, err := parseFlags([]string{"-k2,2n", "-k3,3h"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	sorter := &linesort.Sorter{Compare: linesort.ComparatorFunc(sortCommand.comparator().Compare), Parallel: 1}
+	lines := make([]string, len(input))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(lines, input)
+		sorter.Sort(lines)
+	}
+}
+
+// TestRun проверяет работу sort как фильтра: входные файлы и stdin, вывод и коды выхода
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt": "b 2\na 3\n",
+		// Последняя строка без перевода строки
+		"b.txt": "c 1\nd 0",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		stdout string
+		stderr string
+		code   int
+	}{
+		{"stdin", nil, "c\na\nb\n", "a\nb\nc\n", "", 0},
+		{"stdin dash", []string{"-r", "-"}, "c\na\nb\n", "c\nb\na\n", "", 0},
+		{"files", []string{"-k2,2n", a, b}, "", "d 0\nc 1\nb 2\na 3\n", "", 0},
+		{"file and stdin", []string{a, "-", b}, "a 9\n", "a 3\na 9\nb 2\nc 1\nd 0\n", "", 0},
+		{"input flag", []string{"-i", a, b}, "", "a 3\nb 2\nc 1\nd 0\n", "", 0},
+		{"unterminated line", nil, "b\na", "a\nb\n", "", 0},
+		{"carriage return", nil, "b\r\na\r\n", "a\r\nb\r\n", "", 0},
+		{"zero terminated", []string{"-z"}, "b\x00a\nc\x00", "a\nc\x00b\x00", "", 0},
+		{"zero terminated unique", []string{"-z", "-u"}, "b\x00a\x00b", "a\x00b\x00", "", 0},
+		{"merge", []string{"-m", a, "-"}, "a 1\nc 0\n", "a 1\nb 2\na 3\nc 0\n", "", 0},
+		{"check sorted", []string{"-c"}, "a\nb\n", "", "", 0},
+		{"check disorder", []string{"-c"}, "a\nc\nb\n", "", "sort: -:3: disorder: b\n", 1},
+		{"check file", []string{"-c", "-k1,1", a}, "", "", "sort: " + a + ":2: disorder: a 3\n", 1},
+		{"missing file", []string{filepath.Join(dir, "missing.txt")}, "", "", "sort: open " + filepath.Join(dir, "missing.txt") + ": no such file or directory\n", 2},
+		{"bad key", []string{"-k0"}, "", "", "sort: invalid value \"0\" for flag -k: invalid key \"0\": field number is zero\n", 2},
+		{"help", []string{"-help"}, "", "", "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			code := run(test.args, strings.NewReader(test.stdin), &stdout, &stderr)
+			if code != test.code {
+				t.Errorf("exit code %d, want %d", code, test.code)
+			}
+			if test.name == "help" {
+				if !strings.HasPrefix(stdout.String(), "Usage: sort") {
+					t.Errorf("stdout %q, want usage", stdout.String())
+				}
+			} else if stdout.String() != test.stdout {
+				t.Errorf("stdout %q, want %q", stdout.String(), test.stdout)
+			}
+			if !strings.HasSuffix(stderr.String(), test.stderr) || test.stderr == "" && stderr.Len() > 0 {
+				t.Errorf("stderr %q, want %q", stderr.String(), test.stderr)
+			}
+		})
+	}
+}
+
+// TestRunOutputIsInput проверяет, что -o может указывать на один из входных файлов
+func TestRunOutputIsInput(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "data.txt")
+	other := filepath.Join(dir, "other.txt")
+	if err := os.WriteFile(filename, []byte("c\na\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr strings.Builder
+	if code := run([]string{"-o", filename, filename, other}, strings.NewReader(""), &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+	if data, _ := os.ReadFile(filename); string(data) != "a\nb\nc\n" {
+		t.Errorf("got %q", data)
+	}
+	// Временный файл вывода удален
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("unexpected files in %s: %v", dir, entries)
+	}
+}
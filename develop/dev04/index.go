@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"strings"
+)
+
+// alphabet буквы русского и английского алфавитов по убыванию частоты
+const alphabet = "оеаинтсрвлкмдпуяыьгзбчйхжшюцщэфъёetaoinshrdlcumwfgypbvkjxqz"
+
+// letterPrimes простые числа для букв alphabet. Частым буквам достаются меньшие простые,
+// чтобы произведение для обычного слова помещалось в uint64
+var letterPrimes = func() map[rune]uint64 {
+	primes := make(map[rune]uint64, len(alphabet))
+	candidate := uint64(2)
+	for _, letter := range alphabet {
+		for !isPrime(candidate) {
+			candidate++
+		}
+		primes[letter] = candidate
+		candidate++
+	}
+	return primes
+}()
+
+// isPrime проверяет простоту небольшого числа перебором делителей
+func isPrime(n uint64) bool {
+	for d := uint64(2); d*d <= n; d++ {
+		if n%d == 0 {
+			return false
+		}
+	}
+	return n >= 2
+}
+
+// signature общая подпись всех анаграмм слова. Обычно это произведение простых чисел
+// букв слова: по основной теореме арифметики оно не зависит от порядка букв и
+// различается у слов с разным набором букв. Если в слове есть буква без простого
+// числа или произведение не помещается в uint64, подписью служат отсортированные буквы
+type signature struct {
+	product uint64
+	letters string
+}
+
+// signatureOf вычисляет подпись слова в нижнем регистре
+func signatureOf(word string) signature {
+	product := uint64(1)
+	for _, letter := range word {
+		prime, ok := letterPrimes[letter]
+		if !ok {
+			return signature{letters: sortString(word)}
+		}
+		high, low := bits.Mul64(product, prime)
+		if high != 0 {
+			return signature{letters: sortString(word)}
+		}
+		product = low
+	}
+	return signature{product: product}
+}
+
+// anagramGroup множество анаграмм в индексе
+type anagramGroup struct {
+	// letters - общий для слов множества набор букв
+	letters letterSet
+	// position - номер множества в AnagramIndex.list
+	position int
+	// added - слова в порядке добавления, первое из них - ключ множества
+	added []string
+	// sorted - те же слова по возрастанию
+	sorted []string
+}
+
+// AnagramIndex словарь, сгруппированный по множествам анаграмм. В отличие от findAnagrams,
+// слова добавляются и удаляются по одному, без пересчета всего словаря.
+// Как и в findAnagrams, слова приводятся к нижнему регистру и хранятся без повторов
+type AnagramIndex struct {
+	groups map[signature]*anagramGroup
+	// list и masks - те же множества и маски их букв подряд в памяти, чтобы поиск
+	// по буквам быстро отсеивал множества, не заходя в каждое
+	list  []*anagramGroup
+	masks []uint64
+	size  int
+}
+
+// NewAnagramIndex создает пустой индекс
+func NewAnagramIndex() *AnagramIndex {
+	return &AnagramIndex{groups: make(map[signature]*anagramGroup)}
+}
+
+// LoadAnagramIndex создает индекс из словаря, в котором слова разделены пробельными символами,
+// например по одному слову в строке
+func LoadAnagramIndex(r io.Reader) (*AnagramIndex, error) {
+	index := NewAnagramIndex()
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		index.Add(scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read dictionary: %w", err)
+	}
+	return index, nil
+}
+
+// LoadAnagramIndexFile создает индекс из файла словаря
+func LoadAnagramIndexFile(filename string) (*AnagramIndex, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	index, err := LoadAnagramIndex(bufio.NewReaderSize(file, 1<<16))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return index, nil
+}
+
+// normalizeWord приводит слово к виду, в котором оно хранится в индексе
+func normalizeWord(word string) string {
+	return strings.ToLower(strings.TrimSpace(word))
+}
+
+// Len возвращает количество слов в индексе
+func (index *AnagramIndex) Len() int {
+	return index.size
+}
+
+// Add добавляет слово в индекс. Возвращает false, если слово пустое или уже есть в индексе
+func (index *AnagramIndex) Add(word string) bool {
+	word = normalizeWord(word)
+	if word == "" {
+		return false
+	}
+
+	sig := signatureOf(word)
+	group := index.groups[sig]
+	if group == nil {
+		group = &anagramGroup{letters: newLetterSet(word), position: len(index.list)}
+		index.groups[sig] = group
+		index.list = append(index.list, group)
+		index.masks = append(index.masks, group.letters.mask)
+	}
+
+	// Вставляем слово в отсортированный срез, сохраняя порядок
+	i := sort.SearchStrings(group.sorted, word)
+	if i < len(group.sorted) && group.sorted[i] == word {
+		return false
+	}
+	group.sorted = append(group.sorted, "")
+	copy(group.sorted[i+1:], group.sorted[i:])
+	group.sorted[i] = word
+	group.added = append(group.added, word)
+	index.size++
+	return true
+}
+
+// Remove удаляет слово из индекса. Возвращает false, если слова в индексе нет.
+// Если удалено первое добавленное слово множества, ключом множества становится следующее
+func (index *AnagramIndex) Remove(word string) bool {
+	word = normalizeWord(word)
+	sig := signatureOf(word)
+	group := index.groups[sig]
+	if group == nil {
+		return false
+	}
+
+	i := sort.SearchStrings(group.sorted, word)
+	if i == len(group.sorted) || group.sorted[i] != word {
+		return false
+	}
+	group.sorted = append(group.sorted[:i], group.sorted[i+1:]...)
+	for j, added := range group.added {
+		if added == word {
+			group.added = append(group.added[:j], group.added[j+1:]...)
+			break
+		}
+	}
+	if len(group.sorted) == 0 {
+		index.removeGroup(sig, group)
+	}
+	index.size--
+	return true
+}
+
+// removeGroup удаляет пустое множество, перенося последнее множество списка на его место
+func (index *AnagramIndex) removeGroup(sig signature, group *anagramGroup) {
+	delete(index.groups, sig)
+	last := len(index.list) - 1
+	moved := index.list[last]
+	moved.position = group.position
+	index.list[group.position] = moved
+	index.masks[group.position] = moved.letters.mask
+	index.list[last] = nil
+	index.list = index.list[:last]
+	index.masks = index.masks[:last]
+}
+
+// Lookup возвращает отсортированные по возрастанию слова индекса, которые являются
+// анаграммами word, включая само слово, если оно есть в индексе. Возвращает nil,
+// если таких слов нет
+func (index *AnagramIndex) Lookup(word string) []string {
+	group := index.groups[signatureOf(normalizeWord(word))]
+	if group == nil {
+		return nil
+	}
+	return append([]string(nil), group.sorted...)
+}
+
+// Sets возвращает множества анаграмм из двух и более слов в формате findAnagrams:
+// ключ - первое добавленное слово множества, значение - слова множества по возрастанию
+func (index *AnagramIndex) Sets() *map[string]*[]string {
+	sets := make(map[string]*[]string)
+	for _, group := range index.groups {
+		if len(group.sorted) < 2 {
+			continue
+		}
+		words := append([]string(nil), group.sorted...)
+		sets[group.added[0]] = &words
+	}
+	return &sets
+}
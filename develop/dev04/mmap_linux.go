@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile отображает файл в память только для чтения. Возвращает функцию,
+// снимающую отображение
+func mapFile(filename string) ([]byte, func() error, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Пустой файл отобразить нельзя, а словарем он все равно не является
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	if int64(int(info.Size())) != info.Size() {
+		return nil, nil, fmt.Errorf("%s: file too large to map", filename)
+	}
+
+	data, err := unix.Mmap(int(file.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmap %s: %w", filename, err)
+	}
+	return data, func() error { return unix.Munmap(data) }, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// mapFile на системах, отличных от Linux, читает файл в память целиком
+func mapFile(filename string) ([]byte, func() error, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
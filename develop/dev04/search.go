@@ -0,0 +1,229 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// letterCount буква и число ее повторений
+type letterCount struct {
+	letter rune
+	count  int
+}
+
+// letterSet набор букв с повторениями
+type letterSet struct {
+	letters []letterCount
+	// mask - по биту на каждую букву alphabet, последний бит - для остальных букв.
+	// Если в маске слова есть бит, которого нет в маске набора, слово из набора не собрать
+	mask   uint64
+	length int
+}
+
+// letterBits номера битов букв alphabet в маске letterSet
+var letterBits = func() map[rune]uint {
+	bits := make(map[rune]uint, len(alphabet))
+	for _, letter := range alphabet {
+		bits[letter] = uint(len(bits))
+	}
+	return bits
+}()
+
+// letterBit возвращает бит буквы в маске letterSet
+func letterBit(letter rune) uint64 {
+	if bit, ok := letterBits[letter]; ok {
+		return 1 << bit
+	}
+	return 1 << 63
+}
+
+// newLetterSet возвращает набор букв текста в нижнем регистре без пробельных символов
+func newLetterSet(text string) letterSet {
+	var set letterSet
+	sorted := []rune(sortString(strings.Join(strings.Fields(text), "")))
+	for i, letter := range sorted {
+		if i > 0 && letter == sorted[i-1] {
+			set.letters[len(set.letters)-1].count++
+			continue
+		}
+		set.letters = append(set.letters, letterCount{letter: letter, count: 1})
+		set.mask |= letterBit(letter)
+	}
+	set.length = len(sorted)
+	return set
+}
+
+// counts возвращает число повторений каждой буквы набора pool в наборе s,
+// если s можно собрать из букв pool
+func (s letterSet) counts(pool letterSet) ([]int, bool) {
+	if s.mask&^pool.mask != 0 || s.length > pool.length {
+		return nil, false
+	}
+	counts := make([]int, len(pool.letters))
+	i := 0
+	for _, lc := range s.letters {
+		// Буквы обоих наборов отсортированы, поэтому ищем следующую букву с текущей позиции
+		for i < len(pool.letters) && pool.letters[i].letter < lc.letter {
+			i++
+		}
+		if i == len(pool.letters) || pool.letters[i].letter != lc.letter || pool.letters[i].count < lc.count {
+			return nil, false
+		}
+		counts[i] = lc.count
+	}
+	return counts, true
+}
+
+// candidate множество анаграмм, слова которого можно собрать из букв запроса
+type candidate struct {
+	group  *anagramGroup
+	counts []int
+}
+
+// candidates возвращает множества анаграмм, которые можно собрать из букв pool,
+// от длинных слов к коротким
+func (index *AnagramIndex) candidates(pool letterSet) []candidate {
+	var found []candidate
+	for i, mask := range index.masks {
+		if mask&^pool.mask != 0 {
+			continue
+		}
+		group := index.list[i]
+		if counts, ok := group.letters.counts(pool); ok {
+			found = append(found, candidate{group: group, counts: counts})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		a, b := found[i].group, found[j].group
+		if a.letters.length != b.letters.length {
+			return a.letters.length > b.letters.length
+		}
+		return a.sorted[0] < b.sorted[0]
+	})
+	return found
+}
+
+// SubAnagrams возвращает слова индекса, которые можно составить из букв letters, используя
+// каждую букву не больше раз, чем она встречается в letters. Регистр и пробелы в letters
+// не учитываются. Слова упорядочены от длинных к коротким, слова одной длины - по возрастанию
+func (index *AnagramIndex) SubAnagrams(letters string) []string {
+	var words []string
+	for _, c := range index.candidates(newLetterSet(strings.ToLower(letters))) {
+		words = append(words, c.group.sorted...)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		a, b := utf8.RuneCountInString(words[i]), utf8.RuneCountInString(words[j])
+		if a != b {
+			return a > b
+		}
+		return words[i] < words[j]
+	})
+	return words
+}
+
+// Phrases возвращает фразы не более чем из maxWords слов индекса, которые вместе являются
+// анаграммой letters: используют все буквы letters ровно столько раз, сколько они встречаются.
+// Регистр и пробелы в letters не учитываются. Каждая фраза - набор слов без учета порядка,
+// фразы упорядочены по числу слов, затем по возрастанию
+func (index *AnagramIndex) Phrases(letters string, maxWords int) [][]string {
+	pool := newLetterSet(strings.ToLower(letters))
+	if maxWords < 1 || pool.length == 0 {
+		return nil
+	}
+
+	search := phraseSearch{
+		candidates: index.candidates(pool),
+		remaining:  make([]int, len(pool.letters)),
+		maxWords:   maxWords,
+	}
+	for i, lc := range pool.letters {
+		search.remaining[i] = lc.count
+	}
+	search.find(0, pool.length)
+
+	sort.Slice(search.phrases, func(i, j int) bool {
+		a, b := search.phrases[i], search.phrases[j]
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return strings.Join(a, " ") < strings.Join(b, " ")
+	})
+	return search.phrases
+}
+
+// phraseSearch перебор наборов множеств анаграмм, составляющих фразу
+type phraseSearch struct {
+	candidates []candidate
+	// remaining - сколько раз каждая буква запроса еще не использована
+	remaining []int
+	// chosen - номера выбранных множеств по неубыванию, чтобы не перебирать перестановки
+	chosen   []int
+	maxWords int
+	phrases  [][]string
+}
+
+// find добавляет во фразу множества с номерами от start, пока не останется length букв
+func (s *phraseSearch) find(start, length int) {
+	if length == 0 {
+		s.expand(0, 0, make([]string, 0, len(s.chosen)))
+		return
+	}
+	words := s.maxWords - len(s.chosen)
+	if words == 0 {
+		return
+	}
+
+	for i := start; i < len(s.candidates); i++ {
+		c := s.candidates[i]
+		size := c.group.letters.length
+		// Множества упорядочены по убыванию длины: если оставшихся слов такой длины
+		// не хватит на все буквы, более коротких тем более не хватит
+		if size*words < length {
+			return
+		}
+		if size > length || !s.take(c.counts) {
+			continue
+		}
+		s.chosen = append(s.chosen, i)
+		s.find(i, length-size)
+		s.chosen = s.chosen[:len(s.chosen)-1]
+		s.put(c.counts)
+	}
+}
+
+// take вычитает буквы из оставшихся, если их хватает
+func (s *phraseSearch) take(counts []int) bool {
+	for i, count := range counts {
+		if count > s.remaining[i] {
+			return false
+		}
+	}
+	for i, count := range counts {
+		s.remaining[i] -= count
+	}
+	return true
+}
+
+// put возвращает буквы, вычтенные take
+func (s *phraseSearch) put(counts []int) {
+	for i, count := range counts {
+		s.remaining[i] += count
+	}
+}
+
+// expand превращает выбранные множества во фразы из их слов. Если одно множество выбрано
+// несколько раз, слова из него берутся по неубыванию номеров, чтобы фразы не повторялись
+func (s *phraseSearch) expand(position, from int, phrase []string) {
+	if position == len(s.chosen) {
+		s.phrases = append(s.phrases, append([]string(nil), phrase...))
+		return
+	}
+	words := s.candidates[s.chosen[position]].group.sorted
+	if position == 0 || s.chosen[position] != s.chosen[position-1] {
+		from = 0
+	}
+	for i := from; i < len(words); i++ {
+		s.expand(position+1, i, append(phrase, words[i]))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// anagramLookup словарь, в котором ищутся анаграммы: AnagramIndex или IndexFile
+type anagramLookup interface {
+	Lookup(word string) []string
+}
+
+// anagramsResponse ответ метода /anagrams
+type anagramsResponse struct {
+	Word     string   `json:"word"`
+	Anagrams []string `json:"anagrams"`
+}
+
+// anagramsHandler обрабатывает GET /anagrams?word=... и возвращает слова словаря,
+// которые являются анаграммами word, включая само слово, если оно есть в словаре
+func anagramsHandler(dictionary anagramLookup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		word := normalizeWord(r.URL.Query().Get("word"))
+		if word == "" {
+			http.Error(w, "Bad Request: word is required", http.StatusBadRequest)
+			return
+		}
+
+		response := anagramsResponse{Word: word, Anagrams: dictionary.Lookup(word)}
+		if response.Anagrams == nil {
+			response.Anagrams = []string{}
+		}
+		body, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// loggingMiddleware выводит в лог каждый запрос
+func loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Request: %s %s", r.Method, r.URL)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newServer возвращает обработчик HTTP API словаря анаграмм
+func newServer(dictionary anagramLookup) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/anagrams", anagramsHandler(dictionary))
+	return loggingMiddleware(mux)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/43nvy/wb-level2/develop/internal/atomicfile"
+)
+
+/*
+Формат файла словаря анаграмм. Все числа - uint32 в little-endian, поэтому файл
+читается прямо из отображенной в память области, без разбора при открытии:
+
+	заголовок   magic "ANAGRIX1", число слов, число множеств, размер таблицы, размер области слов
+	таблица     номера множеств+1 по хешу подписи, открытая адресация, 0 - пустая ячейка
+	множества   для каждого множества смещение его слов в области слов и их число
+	слова       слова множеств по возрастанию, каждое - uvarint длины и байты слова
+*/
+
+const (
+	indexMagic      = "ANAGRIX1"
+	indexHeaderSize = len(indexMagic) + 4*4
+	indexGroupSize  = 2 * 4
+)
+
+// errIndexFormat возвращается для файла, который не является словарем анаграмм
+var errIndexFormat = errors.New("not an anagram index file")
+
+// hash перемешивает подпись для таблицы множеств в файле
+func (s signature) hash() uint64 {
+	h := s.product
+	if s.letters != "" {
+		f := fnv.New64a()
+		f.Write([]byte(s.letters))
+		h = f.Sum64()
+	}
+	// Финализатор splitmix64: произведения простых чисел плохо распределены по младшим битам
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// WriteTo записывает индекс в w в формате файла словаря анаграмм
+func (index *AnagramIndex) WriteTo(w io.Writer) (int64, error) {
+	// Упорядочиваем множества, чтобы один и тот же словарь давал один и тот же файл
+	groups := append([]*anagramGroup(nil), index.list...)
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].sorted[0] < groups[j].sorted[0]
+	})
+
+	tableSize := 1
+	for tableSize < 2*len(groups) {
+		tableSize *= 2
+	}
+	table := make([]byte, 4*tableSize)
+	groupData := make([]byte, indexGroupSize*len(groups))
+	var words []byte
+	length := make([]byte, binary.MaxVarintLen64)
+	for i, group := range groups {
+		slot := signatureOf(group.sorted[0]).hash() & uint64(tableSize-1)
+		for binary.LittleEndian.Uint32(table[4*slot:]) != 0 {
+			slot = (slot + 1) & uint64(tableSize-1)
+		}
+		binary.LittleEndian.PutUint32(table[4*slot:], uint32(i+1))
+
+		binary.LittleEndian.PutUint32(groupData[indexGroupSize*i:], uint32(len(words)))
+		binary.LittleEndian.PutUint32(groupData[indexGroupSize*i+4:], uint32(len(group.sorted)))
+		for _, word := range group.sorted {
+			words = append(words, length[:binary.PutUvarint(length, uint64(len(word)))]...)
+			words = append(words, word...)
+		}
+	}
+	if len(words) > math.MaxUint32 {
+		return 0, fmt.Errorf("anagram index too large: %d bytes of words", len(words))
+	}
+
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic)
+	binary.LittleEndian.PutUint32(header[8:], uint32(index.size))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(groups)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(tableSize))
+	binary.LittleEndian.PutUint32(header[20:], uint32(len(words)))
+
+	var written int64
+	for _, part := range [][]byte{header, table, groupData, words} {
+		n, err := w.Write(part)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// WriteIndexFile записывает индекс в файл filename. Старый файл не перезаписывается
+// на месте: сервер, отобразивший его в память, продолжает читать прежний словарь,
+// а при ошибке файл остается целым
+func WriteIndexFile(index *AnagramIndex, filename string) error {
+	return atomicfile.WriteFile(filename, func(w io.Writer) error {
+		buffered := bufio.NewWriterSize(w, 1<<16)
+		if _, err := index.WriteTo(buffered); err != nil {
+			return err
+		}
+		return buffered.Flush()
+	})
+}
+
+// IndexFile словарь анаграмм, открытый из файла только для чтения. Слова не загружаются
+// в память целиком: на Linux файл отображается в память, и Lookup читает только
+// нужные ему ячейки таблицы и слова одного множества
+type IndexFile struct {
+	unmap  func() error
+	words  int
+	table  []byte
+	groups []byte
+	text   []byte
+}
+
+// OpenIndexFile открывает файл словаря анаграмм, записанный WriteIndexFile
+func OpenIndexFile(filename string) (*IndexFile, error) {
+	data, unmap, err := mapFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	index, err := parseIndexFile(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	index.unmap = unmap
+	return index, nil
+}
+
+// parseIndexFile проверяет заголовок и размеры частей файла словаря
+func parseIndexFile(data []byte) (*IndexFile, error) {
+	if len(data) < indexHeaderSize || string(data[:len(indexMagic)]) != indexMagic {
+		return nil, errIndexFormat
+	}
+	words := binary.LittleEndian.Uint32(data[8:])
+	groups := uint64(binary.LittleEndian.Uint32(data[12:]))
+	tableSize := uint64(binary.LittleEndian.Uint32(data[16:]))
+	textSize := uint64(binary.LittleEndian.Uint32(data[20:]))
+
+	// Таблица должна быть степенью двойки и иметь пустые ячейки, иначе поиск не остановится
+	if tableSize == 0 || tableSize&(tableSize-1) != 0 || tableSize < 2*groups {
+		return nil, fmt.Errorf("%w: bad table size %d for %d groups", errIndexFormat, tableSize, groups)
+	}
+	size := uint64(indexHeaderSize) + 4*tableSize + indexGroupSize*groups + textSize
+	if size != uint64(len(data)) {
+		return nil, fmt.Errorf("%w: size %d, expected %d", errIndexFormat, len(data), size)
+	}
+
+	offset := uint64(indexHeaderSize)
+	index := &IndexFile{words: int(words)}
+	index.table = data[offset : offset+4*tableSize]
+	offset += 4 * tableSize
+	index.groups = data[offset : offset+indexGroupSize*groups]
+	offset += indexGroupSize * groups
+	index.text = data[offset:]
+	return index, nil
+}
+
+// Len возвращает количество слов в словаре
+func (index *IndexFile) Len() int {
+	return index.words
+}
+
+// Lookup возвращает отсортированные по возрастанию слова словаря, которые являются
+// анаграммами word, как AnagramIndex.Lookup. Возвращает nil, если таких слов нет
+// или файл поврежден
+func (index *IndexFile) Lookup(word string) []string {
+	sig := signatureOf(normalizeWord(word))
+	mask := uint64(len(index.table)/4 - 1)
+	slot := sig.hash() & mask
+	// В исправном файле пустая ячейка найдется раньше, чем закончится таблица
+	for probe := uint64(0); probe <= mask; probe++ {
+		number := binary.LittleEndian.Uint32(index.table[4*slot:])
+		if number == 0 || uint64(number) > uint64(len(index.groups)/indexGroupSize) {
+			return nil
+		}
+		words := index.group(number - 1)
+		// В ячейку с тем же хешем могло попасть множество с другой подписью
+		if len(words) > 0 && signatureOf(words[0]) == sig {
+			return words
+		}
+		slot = (slot + 1) & mask
+	}
+	return nil
+}
+
+// group читает слова множества с номером number
+func (index *IndexFile) group(number uint32) []string {
+	entry := index.groups[indexGroupSize*uint64(number):]
+	offset := uint64(binary.LittleEndian.Uint32(entry))
+	count := binary.LittleEndian.Uint32(entry[4:])
+	if offset > uint64(len(index.text)) {
+		return nil
+	}
+
+	text := index.text[offset:]
+	// Каждое слово занимает хотя бы байт, так что испорченное число слов не приведет к огромному срезу
+	if uint64(count) > uint64(len(text)) {
+		return nil
+	}
+	words := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		length, n := binary.Uvarint(text)
+		if n <= 0 || length > uint64(len(text)-n) {
+			return nil
+		}
+		words = append(words, string(text[n:n+int(length)]))
+		text = text[n+int(length):]
+	}
+	return words
+}
+
+// Close освобождает память, в которую отображен файл. После Close словарь использовать нельзя
+func (index *IndexFile) Close() error {
+	index.table, index.groups, index.text = nil, nil, nil
+	return index.unmap()
+}
@@ -20,49 +20,127 @@ package main
 */
 
 import (
+	"flag"
 	"fmt"
+	"log"
+	"net/http"
+	"os"
 	"sort"
 	"strings"
 )
 
+// sortString возвращает буквы слова, отсортированные по возрастанию, - общую подпись всех его анаграмм
 func sortString(s string) string {
 	// Преобразовываем строку в массив символов
-	sChars := strings.Split(s, "")
+	sChars := []rune(s)
 	// Сортируем
-	sort.Strings(sChars)
+	sort.Slice(sChars, func(i, j int) bool { return sChars[i] < sChars[j] })
 	// И соединям символы обратно в строку
-	return strings.Join(sChars, "")
+	return string(sChars)
 }
 
-func findAnagrams(words []string) map[string][]string {
-	anagramSets := make(map[string][]string)
+// findAnagrams группирует слова словаря в множества анаграмм. Ключ множества - первое
+// встретившееся слово из него, значение - отсортированные по возрастанию слова множества
+// без повторов. Слова приводятся к нижнему регистру, множества из одного слова отбрасываются
+func findAnagrams(words *[]string) *map[string]*[]string {
+	// firstWords - первое встретившееся слово для каждой подписи
+	firstWords := make(map[string]string)
+	anagramSets := make(map[string]*[]string)
+	seen := make(map[string]bool)
 
-	for _, word := range words {
-		// Приводим слова к нижнему регистру и сортируем символы
-		sortedWord := sortString(strings.ToLower(word))
-		// Добавляем слова в множество анаграмм
-		anagramSets[sortedWord] = append(anagramSets[sortedWord], word)
+	for _, word := range *words {
+		// Приводим слова к нижнему регистру и пропускаем повторы
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+
+		// Добавляем слово в множество анаграмм, ключом которого станет первое слово с той же подписью
+		signature := sortString(word)
+		key, ok := firstWords[signature]
+		if !ok {
+			key = word
+			firstWords[signature] = key
+			anagramSets[key] = &[]string{}
+		}
+		*anagramSets[key] = append(*anagramSets[key], word)
 	}
 
 	// Убираем множества из одного элемента
-	for key, value := range anagramSets {
-		if len(value) < 2 {
+	for key, set := range anagramSets {
+		if len(*set) < 2 {
 			delete(anagramSets, key)
 		} else {
 			// Сортируем множество по возрастанию
-			sort.Strings(anagramSets[key])
+			sort.Strings(*set)
 		}
 	}
 
-	return anagramSets
+	return &anagramSets
+}
+
+// buildIndex строит словарь анаграмм из файла со словами и записывает его в indexFile.
+// Возвращает количество слов в словаре
+func buildIndex(dictionary, indexFile string) (int, error) {
+	index, err := LoadAnagramIndexFile(dictionary)
+	if err != nil {
+		return 0, err
+	}
+	if err := WriteIndexFile(index, indexFile); err != nil {
+		return 0, err
+	}
+	return index.Len(), nil
 }
 
 func main() {
-	words := []string{"пятак", "пятка", "пол", "тяпка", "листок", "слиток", "столик", "кот", "ток", "кто", "сам", "стул"}
-	fmt.Printf("Исходный срез: %s\n", words)
-	anagramSets := findAnagrams(words)
+	buildFlag := flag.String("build", "", "Word list to build the index file from, one or more words per line")
+	indexFlag := flag.String("index", "", "Anagram index file built with -build")
+	serveFlag := flag.String("serve", "", "Serve GET /anagrams?word=... from the index file on the given address")
+	flag.Parse()
+
+	// Без словаря показываем группировку небольшого набора слов
+	if *indexFlag == "" {
+		if *buildFlag != "" || *serveFlag != "" || flag.NArg() > 0 {
+			log.Printf("Error: -index is required\n")
+			os.Exit(1)
+		}
+		words := []string{"пятак", "пятка", "пол", "тяпка", "листок", "слиток", "столик", "кот", "ток", "кто", "сам", "стул"}
+		fmt.Printf("Исходный срез: %s\n", words)
+		anagramSets := findAnagrams(&words)
+
+		for key, set := range *anagramSets {
+			fmt.Printf("Множество анаграмм для %s: %v\n", key, *set)
+		}
+		return
+	}
+
+	if *buildFlag != "" {
+		count, err := buildIndex(*buildFlag, *indexFlag)
+		if err != nil {
+			log.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Index %s built from %d words\n", *indexFlag, count)
+	}
 
-	for _, value := range anagramSets {
-		fmt.Printf("Множество анаграмм для %s: %v\n", value[0], value)
+	index, err := OpenIndexFile(*indexFlag)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer index.Close()
+
+	// Слова из аргументов ищем сразу и выводим по строке на слово
+	for _, word := range flag.Args() {
+		fmt.Printf("%s: %s\n", word, strings.Join(index.Lookup(word), " "))
+	}
+
+	if *serveFlag != "" {
+		log.Printf("Serving %d words on %s", index.Len(), *serveFlag)
+		if err := http.ListenAndServe(*serveFlag, newServer(index)); err != nil {
+			index.Close()
+			log.Fatal("Error starting the server: ", err)
+		}
 	}
 }
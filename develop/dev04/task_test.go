@@ -1 +1,749 @@
 package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/43nvy/wb-level2/develop/internal/atomicfile"
+)
+
+func TestFindAnagrams(t *testing.T) {
+	tests := []struct {
+		name     string
+		words    []string
+		expected map[string][]string
+	}{
+		{
+			name:  "spec example",
+			words: []string{"пятак", "пятка", "тяпка", "листок", "слиток", "столик"},
+			expected: map[string][]string{
+				"пятак":  {"пятак", "пятка", "тяпка"},
+				"листок": {"листок", "слиток", "столик"},
+			},
+		},
+		{
+			name:  "first seen key",
+			words: []string{"тяпка", "пятка", "пятак"},
+			expected: map[string][]string{
+				"тяпка": {"пятак", "пятка", "тяпка"},
+			},
+		},
+		{
+			name:  "lowercase",
+			words: []string{"Кот", "ТОК", "кто"},
+			expected: map[string][]string{
+				"кот": {"кот", "кто", "ток"},
+			},
+		},
+		{
+			name:  "duplicates",
+			words: []string{"кот", "ток", "Кот", "ток", "КОТ"},
+			expected: map[string][]string{
+				"кот": {"кот", "ток"},
+			},
+		},
+		{
+			name:     "duplicates of single word",
+			words:    []string{"сам", "Сам", "САМ"},
+			expected: map[string][]string{},
+		},
+		{
+			name:  "singletons dropped",
+			words: []string{"пол", "лоп", "сам", "стул"},
+			expected: map[string][]string{
+				"пол": {"лоп", "пол"},
+			},
+		},
+		{
+			name:     "different letter counts",
+			words:    []string{"аба", "баа", "абб"},
+			expected: map[string][]string{"аба": {"аба", "баа"}},
+		},
+		{
+			name:     "empty and blank words",
+			words:    []string{"", " ", "ток", " кот "},
+			expected: map[string][]string{"ток": {"кот", "ток"}},
+		},
+		{
+			name:     "ё differs from е",
+			words:    []string{"ёлка", "елка", "калё"},
+			expected: map[string][]string{"ёлка": {"калё", "ёлка"}},
+		},
+		{
+			name:     "latin",
+			words:    []string{"Listen", "Silent", "enlist", "google"},
+			expected: map[string][]string{"listen": {"enlist", "listen", "silent"}},
+		},
+		{
+			name:     "empty dictionary",
+			words:    nil,
+			expected: map[string][]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := findAnagrams(&test.words)
+
+			got := make(map[string][]string, len(*result))
+			for key, set := range *result {
+				got[key] = *set
+			}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("findAnagrams(%q) = %q, want %q", test.words, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestFindAnagramsKeepsInput(t *testing.T) {
+	words := []string{"Кот", "ток"}
+	findAnagrams(&words)
+	if !reflect.DeepEqual(words, []string{"Кот", "ток"}) {
+		t.Errorf("findAnagrams changed its input: %q", words)
+	}
+}
+
+func TestAnagramIndex(t *testing.T) {
+	index := NewAnagramIndex()
+	steps := []struct {
+		op       string
+		word     string
+		ok       bool
+		lookup   string
+		expected []string
+	}{
+		{"add", "Пятка", true, "тяпка", []string{"пятка"}},
+		{"add", "пятак", true, "пятка", []string{"пятак", "пятка"}},
+		{"add", "ПЯТКА", false, "пятак", []string{"пятак", "пятка"}},
+		{"add", " тяпка ", true, "Пятак", []string{"пятак", "пятка", "тяпка"}},
+		{"add", "", false, "", nil},
+		{"add", "кот", true, "ток", []string{"кот"}},
+		{"remove", "пятка", true, "тяпка", []string{"пятак", "тяпка"}},
+		{"remove", "пятка", false, "тяпка", []string{"пятак", "тяпка"}},
+		{"remove", "КОТ", true, "кто", nil},
+		{"remove", "слон", false, "слон", nil},
+		{"add", "пятка", true, "пятка", []string{"пятак", "пятка", "тяпка"}},
+	}
+
+	for _, step := range steps {
+		var ok bool
+		if step.op == "add" {
+			ok = index.Add(step.word)
+		} else {
+			ok = index.Remove(step.word)
+		}
+		if ok != step.ok {
+			t.Errorf("%s(%q) = %v, want %v", step.op, step.word, ok, step.ok)
+		}
+		if result := index.Lookup(step.lookup); !reflect.DeepEqual(result, step.expected) {
+			t.Errorf("after %s(%q): Lookup(%q) = %q, want %q", step.op, step.word, step.lookup, result, step.expected)
+		}
+	}
+	if index.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", index.Len())
+	}
+
+	// Первое добавленное слово множества удалено, ключом стало следующее
+	got := make(map[string][]string)
+	for key, set := range *index.Sets() {
+		got[key] = *set
+	}
+	if expected := map[string][]string{"пятак": {"пятак", "пятка", "тяпка"}}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Sets() = %q, want %q", got, expected)
+	}
+
+	// Удаленные множества не находятся поиском по буквам
+	index.Add("ток")
+	index.Remove("ток")
+	if result := index.SubAnagrams("пятакток"); !reflect.DeepEqual(result, []string{"пятак", "пятка", "тяпка"}) {
+		t.Errorf("SubAnagrams(пятакток) = %q", result)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		anagram bool
+	}{
+		{"листок", "столик", true},
+		{"аба", "абб", false},
+		{"ёлка", "елка", false},
+		{"listen", "silent", true},
+		// Буквы вне алфавитов и цифры сравниваются через отсортированные буквы
+		{"straße", "ßtraes", true},
+		{"a1b2", "2b1a", true},
+		{"a1b2", "a1b3", false},
+		// Произведение простых чисел переполняет uint64
+		{strings.Repeat("щэфъ", 5) + "ё", "ё" + strings.Repeat("ъфэщ", 5), true},
+		{strings.Repeat("щэфъ", 5) + "ё", strings.Repeat("щэфъ", 5) + "ю", false},
+	}
+
+	for _, test := range tests {
+		if anagram := signatureOf(test.a) == signatureOf(test.b); anagram != test.anagram {
+			t.Errorf("signatureOf(%q) == signatureOf(%q) is %v, want %v", test.a, test.b, anagram, test.anagram)
+		}
+	}
+	if sig := signatureOf(strings.Repeat("щэфъ", 5)); sig.letters == "" {
+		t.Errorf("expected overflowing product to fall back to sorted letters")
+	}
+}
+
+// randomDictionary генерирует n случайных русских слов, часть которых - перестановки
+// уже сгенерированных, и повторы в другом регистре
+func randomDictionary(n int, seed int64) []string {
+	rnd := rand.New(rand.NewSource(seed))
+	letters := []rune("абвгдеёжзийклмнопрстуфхцчшщъыьэюя")
+	words := make([]string, n)
+	for i := range words {
+		switch {
+		case i > 0 && rnd.Intn(4) == 0:
+			word := []rune(words[rnd.Intn(i)])
+			rnd.Shuffle(len(word), func(a, b int) { word[a], word[b] = word[b], word[a] })
+			words[i] = string(word)
+		case i > 0 && rnd.Intn(20) == 0:
+			words[i] = strings.ToUpper(words[rnd.Intn(i)])
+		default:
+			word := make([]rune, 3+rnd.Intn(8))
+			for j := range word {
+				word[j] = letters[rnd.Intn(len(letters))]
+			}
+			words[i] = string(word)
+		}
+	}
+	return words
+}
+
+// TestAnagramIndexMatchesFindAnagrams проверяет, что индекс группирует слова так же, как findAnagrams
+func TestAnagramIndexMatchesFindAnagrams(t *testing.T) {
+	words := randomDictionary(20000, 1)
+	index := NewAnagramIndex()
+	for _, word := range words {
+		index.Add(word)
+	}
+
+	expected := *findAnagrams(&words)
+	sets := *index.Sets()
+	if len(sets) != len(expected) {
+		t.Fatalf("index has %d sets, findAnagrams %d", len(sets), len(expected))
+	}
+	for key, set := range expected {
+		if got, ok := sets[key]; !ok || !reflect.DeepEqual(*got, *set) {
+			t.Fatalf("set %q: index has %v, findAnagrams %q", key, got, *set)
+		}
+		for _, word := range *set {
+			if result := index.Lookup(word); !reflect.DeepEqual(result, *set) {
+				t.Fatalf("Lookup(%q) = %q, want %q", word, result, *set)
+			}
+		}
+	}
+}
+
+func TestLoadAnagramIndexFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dict.txt")
+	if err := os.WriteFile(filename, []byte("пятак\nпятка\r\nтяпка  кот\n\nток\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := LoadAnagramIndexFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 5 {
+		t.Errorf("Len() = %d, want 5", index.Len())
+	}
+	if result := index.Lookup("тяпка"); !reflect.DeepEqual(result, []string{"пятак", "пятка", "тяпка"}) {
+		t.Errorf("Lookup(тяпка) = %q", result)
+	}
+
+	if _, err := LoadAnagramIndexFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("expected error for missing dictionary")
+	}
+}
+
+// benchDictionary путь к словарю для замеров, по умолчанию словарь генерируется
+var benchDictionary = flag.String("bench-dict", "", "dictionary file for anagram benchmarks")
+
+// benchWords возвращает слова словаря для замеров
+func benchWords(b *testing.B) []string {
+	b.Helper()
+	if *benchDictionary == "" {
+		return randomDictionary(300000, 2)
+	}
+	data, err := os.ReadFile(*benchDictionary)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+// benchIndex создает индекс из слов словаря
+func benchIndex(words []string) *AnagramIndex {
+	index := NewAnagramIndex()
+	for _, word := range words {
+		index.Add(word)
+	}
+	return index
+}
+
+func BenchmarkFindAnagrams(b *testing.B) {
+	words := benchWords(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		findAnagrams(&words)
+	}
+}
+
+func BenchmarkAnagramIndexBuild(b *testing.B) {
+	words := benchWords(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchIndex(words).Sets()
+	}
+}
+
+// BenchmarkFindAnagramsAddWord - цена одного нового слова, когда словарь пересчитывается целиком
+func BenchmarkFindAnagramsAddWord(b *testing.B) {
+	words := benchWords(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		extended := append(words[:len(words):len(words)], "пятка")
+		findAnagrams(&extended)
+	}
+}
+
+func BenchmarkAnagramIndexAddRemove(b *testing.B) {
+	words := benchWords(b)
+	index := benchIndex(words)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		word := words[i%len(words)]
+		index.Remove(word)
+		index.Add(word)
+	}
+}
+
+func BenchmarkAnagramIndexLookup(b *testing.B) {
+	words := benchWords(b)
+	index := benchIndex(words)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Lookup(words[i%len(words)])
+	}
+}
+
+func BenchmarkSortString(b *testing.B) {
+	words := benchWords(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sortString(strings.ToLower(words[i%len(words)]))
+	}
+}
+
+func BenchmarkSignature(b *testing.B) {
+	words := benchWords(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		signatureOf(strings.ToLower(words[i%len(words)]))
+	}
+}
+
+// newTestIndex создает индекс из слов
+func newTestIndex(words ...string) *AnagramIndex {
+	index := NewAnagramIndex()
+	for _, word := range words {
+		index.Add(word)
+	}
+	return index
+}
+
+func TestSubAnagrams(t *testing.T) {
+	index := newTestIndex("кот", "ток", "Кто", "он", "но", "окно", "кокон", "слон", "о", "тонко", "kot")
+
+	tests := []struct {
+		letters  string
+		expected []string
+	}{
+		{"Окно Т", []string{"тонко", "окно", "кот", "кто", "ток", "но", "он", "о"}},
+		{"кот", []string{"кот", "кто", "ток", "о"}},
+		{"нкоок", []string{"кокон", "окно", "но", "он", "о"}},
+		{"слоны", []string{"слон", "но", "он", "о"}},
+		{"xyz", nil},
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		if result := index.SubAnagrams(test.letters); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("SubAnagrams(%q) = %q, want %q", test.letters, result, test.expected)
+		}
+	}
+}
+
+func TestPhrases(t *testing.T) {
+	index := newTestIndex("кот", "ток", "кто", "он", "но", "окно", "т", "слон")
+
+	tests := []struct {
+		letters  string
+		maxWords int
+		expected [][]string
+	}{
+		{"кот он", 2, [][]string{
+			{"кот", "но"}, {"кот", "он"}, {"кто", "но"}, {"кто", "он"}, {"окно", "т"}, {"ток", "но"}, {"ток", "он"},
+		}},
+		{"Кот Он", 1, nil},
+		{"слон т т", 3, [][]string{{"слон", "т", "т"}}},
+		{"слон т т", 2, nil},
+		{"он но", 2, [][]string{{"но", "но"}, {"но", "он"}, {"он", "он"}}},
+		{"тт", 3, [][]string{{"т", "т"}}},
+		{"кот", 0, nil},
+		{"", 3, nil},
+		{"кот ю", 3, nil},
+	}
+
+	for _, test := range tests {
+		if result := index.Phrases(test.letters, test.maxWords); !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Phrases(%q, %d) = %q, want %q", test.letters, test.maxWords, result, test.expected)
+		}
+	}
+}
+
+// TestPhrasesBruteForce сравнивает Phrases с полным перебором наборов слов
+func TestPhrasesBruteForce(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	letters := []rune("абвгд")
+	randomWord := func(length int) string {
+		word := make([]rune, length)
+		for i := range word {
+			word[i] = letters[rnd.Intn(len(letters))]
+		}
+		return string(word)
+	}
+
+	var words []string
+	index := NewAnagramIndex()
+	for len(words) < 60 {
+		if word := randomWord(1 + rnd.Intn(4)); index.Add(word) {
+			words = append(words, word)
+		}
+	}
+	sort.Strings(words)
+
+	found := 0
+	for query := 0; query < 10; query++ {
+		letters := randomWord(4 + rnd.Intn(4))
+		signature := sortString(letters)
+
+		// Наборы из 1-3 слов по неубыванию номеров, слова в каждой фразе по возрастанию
+		expected := make(map[string]bool)
+		for i := range words {
+			for j := i; j <= len(words); j++ {
+				for k := j; k <= len(words); k++ {
+					phrase := []string{words[i]}
+					if j < len(words) {
+						phrase = append(phrase, words[j])
+					}
+					if k < len(words) {
+						phrase = append(phrase, words[k])
+					}
+					if j == len(words) && k < len(words) {
+						continue
+					}
+					if sortString(strings.Join(phrase, "")) == signature {
+						expected[strings.Join(phrase, " ")] = true
+					}
+				}
+			}
+		}
+
+		got := make(map[string]bool)
+		for _, phrase := range index.Phrases(letters, 3) {
+			phrase = append([]string(nil), phrase...)
+			sort.Strings(phrase)
+			key := strings.Join(phrase, " ")
+			if got[key] {
+				t.Errorf("Phrases(%q): duplicate phrase %q", letters, key)
+			}
+			got[key] = true
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Phrases(%q) = %v, want %v", letters, got, expected)
+		}
+		found += len(got)
+	}
+	if found == 0 {
+		t.Errorf("no phrases found, the test checks nothing")
+	}
+}
+
+// searchIndex словарь из 100 тысяч русских слов для замеров поиска
+func searchIndex(b *testing.B) (*AnagramIndex, []string) {
+	b.Helper()
+	words := randomDictionary(100000, 3)
+	if *benchDictionary != "" {
+		words = benchWords(b)
+	}
+	return benchIndex(words), words
+}
+
+func BenchmarkSubAnagrams(b *testing.B) {
+	index, words := searchIndex(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.SubAnagrams(words[i%len(words)] + "перестановка")
+	}
+}
+
+func BenchmarkPhrases(b *testing.B) {
+	index, words := searchIndex(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Phrases(words[i%len(words)]+words[(i*7+1)%len(words)]+"кот", 3)
+	}
+}
+
+// TestIndexFile проверяет, что словарь, записанный в файл, находит те же анаграммы, что и индекс
+func TestIndexFile(t *testing.T) {
+	words := append(randomDictionary(5000, 4), "straße", "ßtraes", strings.Repeat("щэфъ", 5), strings.Repeat("ъфэщ", 5))
+	index := benchIndex(words)
+	filename := filepath.Join(t.TempDir(), "index.anagram")
+	if err := WriteIndexFile(index, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := OpenIndexFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if file.Len() != index.Len() {
+		t.Errorf("Len() = %d, want %d", file.Len(), index.Len())
+	}
+	for _, word := range append(words, "отсутствует", "ПЯТКА", "") {
+		if got, want := file.Lookup(word), index.Lookup(word); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Lookup(%q) = %q, want %q", word, got, want)
+		}
+	}
+
+	// Один и тот же словарь дает один и тот же файл
+	var first, second bytes.Buffer
+	index.WriteTo(&first)
+	benchIndex(words).WriteTo(&second)
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("index file is not reproducible")
+	}
+}
+
+func TestIndexFileEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := NewAnagramIndex().WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	file, err := parseIndexFile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Len() != 0 || file.Lookup("кот") != nil {
+		t.Errorf("empty index file: Len() = %d, Lookup(кот) = %q", file.Len(), file.Lookup("кот"))
+	}
+}
+
+func TestIndexFileCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	newTestIndex("кот", "ток", "пятка", "пятак").WriteTo(&buf)
+	valid := buf.Bytes()
+
+	corrupt := func(change func(data []byte) []byte) []byte {
+		return change(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", corrupt(func(data []byte) []byte { data[0] = 'X'; return data })},
+		{"truncated", valid[:len(valid)-1]},
+		{"trailing data", append(append([]byte(nil), valid...), 0)},
+		{"table size", corrupt(func(data []byte) []byte { data[16] = 3; return data })},
+	}
+	for _, test := range tests {
+		if _, err := parseIndexFile(test.data); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	// Испорченные ячейки и слова не приводят к панике и зацикливанию
+	for i := indexHeaderSize; i < len(valid); i++ {
+		data := corrupt(func(data []byte) []byte { data[i] = 0xff; return data })
+		file, err := parseIndexFile(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Lookup("кот")
+		file.Lookup("пятка")
+	}
+
+	filename := filepath.Join(t.TempDir(), "dict.txt")
+	os.WriteFile(filename, []byte("кот\nток\n"), 0o644)
+	if _, err := OpenIndexFile(filename); err == nil {
+		t.Errorf("expected error for a word list opened as index")
+	}
+}
+
+// TestWriteIndexFileReplaces проверяет, что запись словаря не портит уже открытый файл,
+// а неудачная запись оставляет прежний словарь
+func TestWriteIndexFileReplaces(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "index.anagram")
+	if err := WriteIndexFile(newTestIndex("кот", "ток"), filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filename, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old, err := OpenIndexFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+	before, _ := os.ReadFile(filename)
+
+	// Запись обрывается на середине
+	failure := errors.New("disk full")
+	err = atomicfile.WriteFile(filename, func(w io.Writer) error {
+		w.Write(before[:len(before)/2])
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("atomicfile.WriteFile() = %v, want %v", err, failure)
+	}
+	if after, _ := os.ReadFile(filename); !bytes.Equal(after, before) {
+		t.Errorf("failed write changed the index file")
+	}
+
+	// Новый словарь не меняет то, что видит открытый старый
+	if err := WriteIndexFile(newTestIndex("пятак", "пятка"), filename); err != nil {
+		t.Fatal(err)
+	}
+	if result := old.Lookup("кто"); !reflect.DeepEqual(result, []string{"кот", "ток"}) {
+		t.Errorf("old index: Lookup(кто) = %q", result)
+	}
+	reopened, err := OpenIndexFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if result := reopened.Lookup("тяпка"); !reflect.DeepEqual(result, []string{"пятак", "пятка"}) {
+		t.Errorf("new index: Lookup(тяпка) = %q", result)
+	}
+
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("index file mode: %v, %v, want 0600", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	dictionary := filepath.Join(dir, "dict.txt")
+	indexFile := filepath.Join(dir, "index.anagram")
+	if err := os.WriteFile(dictionary, []byte("Пятак\nпятка\nтяпка\nкот\nпятак\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := buildIndex(dictionary, indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("buildIndex() = %d words, want 4", count)
+	}
+	file, err := OpenIndexFile(indexFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if result := file.Lookup("Тяпка"); !reflect.DeepEqual(result, []string{"пятак", "пятка", "тяпка"}) {
+		t.Errorf("Lookup(Тяпка) = %q", result)
+	}
+
+	if _, err := buildIndex(filepath.Join(dir, "missing.txt"), indexFile); err == nil {
+		t.Errorf("expected error for missing word list")
+	}
+}
+
+func TestAnagramsHandler(t *testing.T) {
+	server := newServer(newTestIndex("пятак", "пятка", "тяпка", "кот"))
+
+	tests := []struct {
+		method   string
+		target   string
+		status   int
+		expected anagramsResponse
+	}{
+		{http.MethodGet, "/anagrams?word=%D0%A2%D1%8F%D0%BF%D0%BA%D0%B0", http.StatusOK,
+			anagramsResponse{Word: "тяпка", Anagrams: []string{"пятак", "пятка", "тяпка"}}},
+		{http.MethodGet, "/anagrams?word=ток", http.StatusOK, anagramsResponse{Word: "ток", Anagrams: []string{"кот"}}},
+		{http.MethodGet, "/anagrams?word=слон", http.StatusOK, anagramsResponse{Word: "слон", Anagrams: []string{}}},
+		{http.MethodGet, "/anagrams", http.StatusBadRequest, anagramsResponse{}},
+		{http.MethodGet, "/anagrams?word=+", http.StatusBadRequest, anagramsResponse{}},
+		{http.MethodPost, "/anagrams?word=кот", http.StatusMethodNotAllowed, anagramsResponse{}},
+		{http.MethodGet, "/words?word=кот", http.StatusNotFound, anagramsResponse{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, httptest.NewRequest(test.method, test.target, nil))
+
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %q", contentType)
+			}
+			var response anagramsResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response, test.expected) {
+				t.Errorf("response = %+v, want %+v", response, test.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkIndexFileLookup(b *testing.B) {
+	words := benchWords(b)
+	filename := filepath.Join(b.TempDir(), "index.anagram")
+	if err := WriteIndexFile(benchIndex(words), filename); err != nil {
+		b.Fatal(err)
+	}
+	file, err := OpenIndexFile(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file.Lookup(words[i%len(words)])
+	}
+}
@@ -0,0 +1,71 @@
+// Package atomicfile записывает файлы целиком или никак: данные пишутся во временный
+// файл рядом с целевым, который затем переименовывается поверх него
+package atomicfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// WriteFile записывает файл filename функцией write через временный файл в том же каталоге,
+// сбрасывает его на диск и переименовывает поверх filename. Поэтому filename может быть
+// входным файлом write, при ошибке старое содержимое сохраняется, а процессы, открывшие
+// или отобразившие старый файл в память, продолжают видеть прежние данные.
+// Символическая ссылка заменяется не сама, а файл, на который она указывает. Права
+// существующего файла сохраняются, новый файл создается с правами 0644 с учетом umask
+func WriteFile(filename string, write func(io.Writer) error) error {
+	target, err := filepath.EvalSymlinks(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		target = filename
+	} else if err != nil {
+		return err
+	}
+
+	// Права нового файла ограничит umask, права существующего выставляем явно
+	perm, keepPerm := os.FileMode(0o644), false
+	if info, err := os.Stat(target); err == nil {
+		perm, keepPerm = info.Mode().Perm(), true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	file, err := createTemp(filepath.Dir(target), "."+filepath.Base(target)+"-", perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		return err
+	}
+	if keepPerm {
+		if err := file.Chmod(perm); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), target)
+}
+
+// createTemp создает новый файл с уникальным именем в каталоге dir, как os.CreateTemp,
+// но с правами perm с учетом umask вместо 0600
+func createTemp(dir, prefix string, perm os.FileMode) (*os.File, error) {
+	for try := 0; ; try++ {
+		suffix := strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano()+int64(try), 36)
+		file, err := os.OpenFile(filepath.Join(dir, prefix+suffix), os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, os.ErrExist) && try < 10000 {
+			continue
+		}
+		return file, err
+	}
+}
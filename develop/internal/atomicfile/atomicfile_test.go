@@ -0,0 +1,74 @@
+package atomicfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWriteFile проверяет замену файла через символическую ссылку с сохранением прав
+// и то, что неудачная запись оставляет прежнее содержимое
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	private := filepath.Join(dir, "private.txt")
+	if err := os.WriteFile(private, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(private, link); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	failure := errors.New("disk full")
+	tests := []struct {
+		name     string
+		filename string
+		data     string
+		err      error
+		expected string
+	}{
+		{"file", private, "new", nil, "new"},
+		{"symlink", link, "via link", nil, "via link"},
+		{"failed write", link, "partial", failure, "via link"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := WriteFile(test.filename, func(w io.Writer) error {
+				io.WriteString(w, test.data)
+				return test.err
+			})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("WriteFile() = %v, want %v", err, test.err)
+			}
+			if data, _ := os.ReadFile(private); string(data) != test.expected {
+				t.Errorf("got %q, want %q", data, test.expected)
+			}
+			if info, err := os.Stat(private); err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("mode: %v, %v, want 0600", info, err)
+			}
+			if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+				t.Errorf("symlink was replaced: %v, %v", info, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+				t.Errorf("temporary files left: %v", entries)
+			}
+		})
+	}
+
+	// Новый файл создается с правами 0644 с учетом umask, а не 0600, как у os.CreateTemp
+	created, probe := filepath.Join(dir, "new.txt"), filepath.Join(t.TempDir(), "probe.txt")
+	if err := WriteFile(created, func(w io.Writer) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(probe, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.Stat(created)
+	want, _ := os.Stat(probe)
+	if got == nil || want == nil || got.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("new file mode: %v, want %v", got, want)
+	}
+}
@@ -3,7 +3,10 @@ module github.com/43nvy/wb-level2
 go 1.18
 
 require (
-	github.com/beevik/ntp v1.3.0 // indirect
-	golang.org/x/net v0.11.0 // indirect
-	golang.org/x/sys v0.10.0 // indirect
+	github.com/beevik/ntp v1.3.0
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/sys v0.10.0
+	golang.org/x/text v0.13.0
 )
+
+require golang.org/x/net v0.11.0 // indirect